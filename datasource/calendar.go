@@ -0,0 +1,153 @@
+// Functions for exporting courses as iCalendar files, following RFC 5545.
+
+package datasource
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	_ "time/tzdata" // Calendars need America/New_York, even without system tzdata.
+
+	"golang.org/x/exp/slices"
+)
+
+// CalendarTimeZone is the time zone of course meeting times.
+const CalendarTimeZone = "America/New_York"
+
+// Definition of CalendarTimeZone, with the US daylight saving rules since 2007.
+const calendarVTimezone = `BEGIN:VTIMEZONE
+TZID:America/New_York
+BEGIN:DAYLIGHT
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+TZNAME:EDT
+DTSTART:20070311T020000
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU
+END:DAYLIGHT
+BEGIN:STANDARD
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+TZNAME:EST
+DTSTART:20071104T020000
+RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU
+END:STANDARD
+END:VTIMEZONE`
+
+// Days of the week, as written in the BYDAY rule of a recurrence.
+var icalDays = [7]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// WriteCalendar writes an iCalendar file to w, with a weekly recurring event
+// for each meeting pattern of the courses. Patterns without meeting times or
+// dates are left out, since they cannot be placed on a calendar.
+func WriteCalendar(w io.Writer, courses []Course, now time.Time) error {
+	loc, err := time.LoadLocation(CalendarTimeZone)
+	if err != nil {
+		return err
+	}
+	cw := &calendarWriter{w: bufio.NewWriter(w)}
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:-//classes.wtf//Course Calendar//EN")
+	cw.line("CALSCALE:GREGORIAN")
+	for _, line := range strings.Split(calendarVTimezone, "\n") {
+		cw.line(line)
+	}
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, course := range courses {
+		for i, pattern := range course.MeetingPatterns {
+			cw.event(course, i, pattern, loc, stamp)
+		}
+	}
+	cw.line("END:VCALENDAR")
+	return cw.w.Flush()
+}
+
+type calendarWriter struct {
+	w *bufio.Writer
+}
+
+// Write a content line, folded so that no line is longer than 75 octets.
+func (cw *calendarWriter) line(s string) {
+	width := 75
+	for len(s) > width {
+		// Avoid splitting a multi-byte UTF-8 sequence across lines.
+		n := width
+		for n > 0 && s[n]&0xC0 == 0x80 {
+			n--
+		}
+		cw.w.WriteString(s[:n] + "\r\n ")
+		s = s[n:]
+		width = 74 // Continuation lines start with a space.
+	}
+	cw.w.WriteString(s + "\r\n")
+}
+
+// Write a recurring event for a meeting pattern, if it has times and dates.
+func (cw *calendarWriter) event(course Course, i int, pattern MeetingPattern, loc *time.Location, stamp string) {
+	start, end, ok := pattern.Times()
+	days := pattern.Weekdays()
+	if !ok || len(days) == 0 {
+		return
+	}
+	startDate, err := time.ParseInLocation(DateLayout, pattern.StartDate, loc)
+	if err != nil {
+		return
+	}
+	endDate, err := time.ParseInLocation(DateLayout, pattern.EndDate, loc)
+	if err != nil || endDate.Before(startDate) {
+		return
+	}
+
+	// The first event must be on one of the meeting days.
+	first := startDate
+	for !slices.Contains(days, first.Weekday()) {
+		first = first.AddDate(0, 0, 1)
+	}
+	if first.After(endDate) {
+		return
+	}
+	byDay := make([]string, len(days))
+	for j, day := range days {
+		byDay[j] = icalDays[day]
+	}
+	// UNTIL must be in UTC when the start time has a time zone.
+	until := endDate.AddDate(0, 0, 1).Add(-time.Second).UTC()
+
+	var instructors []string
+	for _, instructor := range course.Instructors {
+		instructors = append(instructors, instructor.Name)
+	}
+	description := []string{course.Title}
+	if len(instructors) > 0 {
+		description = append(description, "Instructors: "+strings.Join(instructors, ", "))
+	}
+	description = append(description, fmt.Sprintf("Subject: %s (%s)", course.Subject, course.SubjectDescription))
+
+	cw.line("BEGIN:VEVENT")
+	cw.line(fmt.Sprintf("UID:%s-%d@classes.wtf", course.Id, i))
+	cw.line("DTSTAMP:" + stamp)
+	cw.line(fmt.Sprintf("DTSTART;TZID=%s:%s", CalendarTimeZone, icalLocalTime(first, start)))
+	cw.line(fmt.Sprintf("DTEND;TZID=%s:%s", CalendarTimeZone, icalLocalTime(first, end)))
+	cw.line(fmt.Sprintf("RRULE:FREQ=WEEKLY;BYDAY=%s;UNTIL=%s",
+		strings.Join(byDay, ","), until.Format("20060102T150405Z")))
+	cw.line("SUMMARY:" + icalEscape(fmt.Sprintf("%s %s: %s", course.Subject, course.CatalogNumber, course.Title)))
+	cw.line("DESCRIPTION:" + icalEscape(strings.Join(description, "\n")))
+	cw.line("END:VEVENT")
+}
+
+// Format a date and a time of day in minutes as an iCalendar local time.
+func icalLocalTime(date time.Time, minutes int) string {
+	return fmt.Sprintf("%sT%02d%02d00", date.Format("20060102"), minutes/60, minutes%60)
+}
+
+// Escape special characters in an iCalendar text value.
+func icalEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\n", `\n`,
+	).Replace(s)
+}
@@ -0,0 +1,85 @@
+package datasource
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+func testCalendarCourses() []Course {
+	return []Course{
+		{
+			// Spring term, which starts in EST and ends in EDT.
+			Id: "cs50", Subject: "COMPSCI", SubjectDescription: "Computer Science", CatalogNumber: "50",
+			Title: "Introduction to Computer Science; Programming, Algorithms\\Data",
+			Instructors: []Instructor{
+				{Name: "David J. Malan"},
+				{Name: "Doug Lloyd"},
+			},
+			MeetingPatterns: []MeetingPattern{
+				{
+					// January 23 is a Monday, so the first event is on Tuesday.
+					StartTime: "10:30", EndTime: "11:45", StartDate: "2023-01-23", EndDate: "2023-05-01",
+					MeetsOnTuesday: true, MeetsOnThursday: true,
+				},
+				{StartTime: "13:00", EndTime: "14:00", StartDate: "2023-01-23", EndDate: "2023-05-01"},
+				{StartTime: "13:00", EndTime: "14:00", MeetsOnFriday: true},
+				{StartDate: "2023-01-23", EndDate: "2023-05-01", MeetsOnFriday: true},
+				{
+					// January 24 to 28 is Tuesday to Saturday.
+					StartTime: "09:00", EndTime: "10:00", StartDate: "2023-01-24", EndDate: "2023-01-28",
+					MeetsOnMonday: true,
+				},
+			},
+		},
+		{
+			// Fall term, which starts in EDT and ends in EST. The long title
+			// places a multi-byte character across the 75-octet fold.
+			Id: "hist1", Subject: "HIST", SubjectDescription: "History", CatalogNumber: "1",
+			Title: "Émigrés and Exiles: Europe’s Displaced Peoples, 1789–1945, in Lectures",
+			MeetingPatterns: []MeetingPattern{{
+				StartTime: "09:00:00", EndTime: "10:15:00", StartDate: "2022-09-01", EndDate: "2022-12-07",
+				MeetsOnMonday: true, MeetsOnWednesday: true, MeetsOnFriday: true,
+			}},
+		},
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2023, 1, 2, 15, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+	if err := WriteCalendar(&buf, testCalendarCourses(), now); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	for _, line := range strings.SplitAfter(string(got), "\r\n") {
+		if len(line) > 75+len("\r\n") {
+			t.Errorf("line is longer than 75 octets: %q", line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a UTF-8 character: %q", line)
+		}
+	}
+
+	golden := filepath.Join("testdata", "calendar.ics")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("calendar differs from %s, rerun with -update to see the changes:\n%s", golden, got)
+	}
+}
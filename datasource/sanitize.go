@@ -4,6 +4,7 @@ package datasource
 
 import (
 	"html"
+	"strings"
 
 	"github.com/microcosm-cc/bluemonday"
 )
@@ -11,6 +12,9 @@ import (
 var ugcPolicy = bluemonday.UGCPolicy()
 var strictPolicy = bluemonday.StrictPolicy()
 
+// Like strictPolicy, but words in separate elements aren't joined together.
+var plainTextPolicy = bluemonday.StrictPolicy().AddSpaceWhenStrippingTag(true)
+
 func sanitizeHtml(content string) string {
 	return ugcPolicy.Sanitize(content)
 }
@@ -18,3 +22,10 @@ func sanitizeHtml(content string) string {
 func removeTags(content string) string {
 	return html.UnescapeString(strictPolicy.Sanitize(content))
 }
+
+// PlainText strips all HTML tags from content, leaving only unescaped text.
+// Tags are replaced by spaces, and runs of whitespace are collapsed.
+func PlainText(content string) string {
+	text := html.UnescapeString(plainTextPolicy.Sanitize(content))
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,20 @@
+package datasource
+
+import "testing"
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		html, want string
+	}{
+		{"<ul><li>Graphs</li><li>Trees</li></ul><p>Algorithms</p>", "Graphs Trees Algorithms"},
+		{"<p>First line<br/>Second line</p>", "First line Second line"},
+		{"<p>Proofs &amp; <i>data</i> structures.</p>\n\n<p> Then  more. </p>", "Proofs & data structures. Then more."},
+		{"No tags at all", "No tags at all"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := PlainText(tt.html); got != tt.want {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package datasource
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of dates in meeting patterns, like "2023-01-23".
+const DateLayout = "2006-01-02"
+
+// Meeting is a block of time that recurs every week on one day, between two
+// dates. This is the unit used to check courses for conflicts.
+type Meeting struct {
+	Day   time.Weekday
+	Start int // Start time, in minutes since midnight.
+	End   int // End time, in minutes since midnight.
+
+	// StartDate and EndDate are the first and last dates of the meeting,
+	// inclusive. Either is the zero time if unknown, which leaves that end of
+	// the range unbounded.
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Meetings returns the weekly meetings of the pattern, one for each day. It
+// returns nil if the pattern is missing its meeting times.
+func (p MeetingPattern) Meetings() []Meeting {
+	start, end, ok := p.Times()
+	if !ok {
+		return nil
+	}
+	startDate, _ := time.Parse(DateLayout, p.StartDate)
+	endDate, _ := time.Parse(DateLayout, p.EndDate)
+	var meetings []Meeting
+	for _, day := range p.Weekdays() {
+		meetings = append(meetings, Meeting{
+			Day:       day,
+			Start:     start,
+			End:       end,
+			StartDate: startDate,
+			EndDate:   endDate,
+		})
+	}
+	return meetings
+}
+
+// Meetings returns the weekly meetings of all of the course's patterns.
+func (c Course) Meetings() []Meeting {
+	var meetings []Meeting
+	for _, pattern := range c.MeetingPatterns {
+		meetings = append(meetings, pattern.Meetings()...)
+	}
+	return meetings
+}
+
+// Intersect returns the time when both meetings take place, if any. Meetings
+// that only touch, with one ending as the other starts, do not intersect.
+func (m Meeting) Intersect(o Meeting) (Meeting, bool) {
+	if m.Day != o.Day || m.Start >= o.End || o.Start >= m.End {
+		return Meeting{}, false
+	}
+	both := Meeting{
+		Day:       m.Day,
+		Start:     max(m.Start, o.Start),
+		End:       min(m.End, o.End),
+		StartDate: m.StartDate,
+		EndDate:   m.EndDate,
+	}
+	if both.StartDate.IsZero() || o.StartDate.After(both.StartDate) {
+		both.StartDate = o.StartDate
+	}
+	if both.EndDate.IsZero() || (!o.EndDate.IsZero() && o.EndDate.Before(both.EndDate)) {
+		both.EndDate = o.EndDate
+	}
+	if !both.occurs() {
+		return Meeting{}, false
+	}
+	return both, true
+}
+
+// Overlaps reports whether the two meetings ever take place at the same time.
+func (m Meeting) Overlaps(o Meeting) bool {
+	_, ok := m.Intersect(o)
+	return ok
+}
+
+// Reports whether the meeting's day of the week falls within its date range
+// at least once.
+func (m Meeting) occurs() bool {
+	if m.StartDate.IsZero() || m.EndDate.IsZero() {
+		return true
+	}
+	if m.EndDate.Before(m.StartDate) {
+		return false
+	}
+	offset := (int(m.Day) - int(m.StartDate.Weekday()) + 7) % 7
+	return !m.StartDate.AddDate(0, 0, offset).After(m.EndDate)
+}
+
+// FormatClock formats a number of minutes since midnight as a time of day,
+// like "13:30". This is the inverse of ParseClock.
+func FormatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// ParseClock parses a time of day like "13:30" or "13:30:00" into the number
+// of minutes since midnight, ignoring seconds. The end of the day is "24:00".
+func ParseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	var fields [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || i > 0 && n > 59 {
+			return 0, fmt.Errorf("invalid time of day %q", s)
+		}
+		fields[i] = n
+	}
+	hours, minutes, seconds := fields[0], fields[1], fields[2]
+	if hours > 24 || hours == 24 && (minutes > 0 || seconds > 0) {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// Weekdays returns the days of the week that the pattern meets on, starting
+// from Sunday.
+func (p MeetingPattern) Weekdays() []time.Weekday {
+	meets := [7]bool{
+		time.Sunday:    p.MeetsOnSunday,
+		time.Monday:    p.MeetsOnMonday,
+		time.Tuesday:   p.MeetsOnTuesday,
+		time.Wednesday: p.MeetsOnWednesday,
+		time.Thursday:  p.MeetsOnThursday,
+		time.Friday:    p.MeetsOnFriday,
+		time.Saturday:  p.MeetsOnSaturday,
+	}
+	var days []time.Weekday
+	for day, ok := range meets {
+		if ok {
+			days = append(days, time.Weekday(day))
+		}
+	}
+	return days
+}
+
+// Times returns the start and end of the pattern's meetings, in minutes since
+// midnight. It returns false if the times are missing or invalid.
+func (p MeetingPattern) Times() (start, end int, ok bool) {
+	start, err := ParseClock(p.StartTime)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err = ParseClock(p.EndTime)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
@@ -0,0 +1,123 @@
+package datasource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeetingOverlaps(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(DateLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	meeting := func(day time.Weekday, start, end, startDate, endDate string) Meeting {
+		m := Meeting{Day: day}
+		m.Start, _ = ParseClock(start)
+		m.End, _ = ParseClock(end)
+		if startDate != "" {
+			m.StartDate = date(startDate)
+		}
+		if endDate != "" {
+			m.EndDate = date(endDate)
+		}
+		return m
+	}
+	spring := meeting(time.Monday, "10:00", "11:00", "2023-01-23", "2023-05-01")
+	tests := []struct {
+		name string
+		a, b Meeting
+		want bool
+	}{
+		{"same time", spring, spring, true},
+		{"different days", spring, meeting(time.Tuesday, "10:00", "11:00", "2023-01-23", "2023-05-01"), false},
+		{"partial time overlap", spring, meeting(time.Monday, "10:30", "12:00", "2023-01-23", "2023-05-01"), true},
+		{"touching times", spring, meeting(time.Monday, "11:00", "12:00", "2023-01-23", "2023-05-01"), false},
+		{"touching times, reversed", meeting(time.Monday, "09:00", "10:00", "2023-01-23", "2023-05-01"), spring, false},
+		{
+			"separate half terms",
+			meeting(time.Monday, "10:00", "11:00", "2023-01-23", "2023-03-10"),
+			meeting(time.Monday, "10:00", "11:00", "2023-03-20", "2023-05-01"),
+			false,
+		},
+		{
+			"half term within the full term",
+			spring,
+			meeting(time.Monday, "10:00", "11:00", "2023-03-20", "2023-05-01"),
+			true,
+		},
+		{
+			// The date ranges share only Friday, March 10.
+			"half terms sharing a Friday, meeting on Fridays",
+			meeting(time.Friday, "10:00", "11:00", "2023-01-23", "2023-03-10"),
+			meeting(time.Friday, "10:00", "11:00", "2023-03-10", "2023-05-01"),
+			true,
+		},
+		{
+			"half terms sharing a Friday, meeting on Mondays",
+			meeting(time.Monday, "10:00", "11:00", "2023-01-23", "2023-03-10"),
+			meeting(time.Monday, "10:00", "11:00", "2023-03-10", "2023-05-01"),
+			false,
+		},
+		{"unbounded dates", spring, meeting(time.Monday, "10:00", "11:00", "", ""), true},
+		{"unbounded start", spring, meeting(time.Monday, "10:00", "11:00", "", "2023-01-23"), true},
+		{"unbounded start, ending before", spring, meeting(time.Monday, "10:00", "11:00", "", "2023-01-22"), false},
+		{"unbounded end", spring, meeting(time.Monday, "10:00", "11:00", "2023-05-01", ""), true},
+		{"unbounded end, starting after", spring, meeting(time.Monday, "10:00", "11:00", "2023-05-02", ""), false},
+		{
+			// January 24 to 28 is Tuesday to Saturday, so a Monday never occurs.
+			"day never in the date range",
+			meeting(time.Monday, "10:00", "11:00", "2023-01-24", "2023-01-28"),
+			meeting(time.Monday, "10:00", "11:00", "", ""),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Overlaps(tt.b); got != tt.want {
+			t.Errorf("%s: a.Overlaps(b) = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := tt.b.Overlaps(tt.a); got != tt.want {
+			t.Errorf("%s: b.Overlaps(a) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+		ok   bool
+	}{
+		{"00:00", 0, true},
+		{"09:05", 545, true},
+		{"13:30", 810, true},
+		{"13:30:00", 810, true},
+		{"23:59:59", 1439, true},
+		{"24:00", 1440, true},
+		{"24:00:00", 1440, true},
+		{"24:01", 0, false},
+		{"24:59", 0, false},
+		{"24:00:01", 0, false},
+		{"25:00", 0, false},
+		{"12:60", 0, false},
+		{"12:30:60", 0, false},
+		{"-1:30", 0, false},
+		{"12", 0, false},
+		{"12:30:00:00", 0, false},
+		{"noon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseClock(tt.s)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseClock(%q) = %d, %v; want %d, ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+	for minutes := 0; minutes <= 24*60; minutes++ {
+		if got, err := ParseClock(FormatClock(minutes)); got != minutes || err != nil {
+			t.Errorf("ParseClock(FormatClock(%d)) = %d, %v", minutes, got, err)
+		}
+	}
+}
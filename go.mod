@@ -1,6 +1,6 @@
 module classes.wtf
 
-go 1.18
+go 1.21
 
 require (
 	github.com/NYTimes/gziphandler v1.1.1
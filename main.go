@@ -5,9 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"classes.wtf/datasource"
 	"classes.wtf/server"
@@ -119,19 +123,202 @@ func main() {
 
 		log.Printf("wrote %d courses", len(courses))
 
+	case "calendar":
+		calendarCmd := flag.NewFlagSet("calendar", flag.ExitOnError)
+		data := calendarCmd.String("data", "data/courses.json", "path to the data file")
+		out := calendarCmd.String("o", "", "path to write the calendar to, instead of stdout")
+		calendarCmd.Usage = func() {
+			fmt.Fprintf(calendarCmd.Output(), "usage: %s calendar [flags] <course id>...\n", os.Args[0])
+			calendarCmd.PrintDefaults()
+		}
+		calendarCmd.Parse(os.Args[2:])
+		if calendarCmd.NArg() == 0 {
+			calendarCmd.Usage()
+			os.Exit(2)
+		}
+
+		file, err := os.Open(*data)
+		if err != nil {
+			log.Fatalf("failed to open %s: %v", *data, err)
+		}
+		var courses []datasource.Course
+		if err = json.NewDecoder(file).Decode(&courses); err != nil {
+			log.Fatalf("failed to parse %s: %v", *data, err)
+		}
+		byId := make(map[string]datasource.Course, len(courses))
+		for _, course := range courses {
+			byId[course.Id] = course
+		}
+		var selected []datasource.Course
+		for _, id := range calendarCmd.Args() {
+			course, ok := byId[id]
+			if !ok {
+				log.Fatalf("course %q not found in %s", id, *data)
+			}
+			selected = append(selected, course)
+		}
+
+		if *out == "" {
+			err = datasource.WriteCalendar(os.Stdout, selected, time.Now())
+		} else {
+			var w *os.File
+			if w, err = os.Create(*out); err != nil {
+				log.Fatalf("failed to create %s: %v", *out, err)
+			}
+			if err = datasource.WriteCalendar(w, selected, time.Now()); err == nil {
+				err = w.Close() // Only close files that we opened, not stdout.
+			}
+		}
+		if err != nil {
+			log.Fatalf("failed to write calendar: %v", err)
+		}
+
 	case "server":
 		serverCmd := flag.NewFlagSet("server", flag.ExitOnError)
 		data := serverCmd.String("data", "", "path or url for the data file")
 		static := serverCmd.String("static", "", "path to static website files")
 		local := serverCmd.Bool("local", false, "set to use local mode")
+		synonyms := serverCmd.String("synonyms", "", "path to a file of synonym groups")
+		backend := serverCmd.String("backend", envString("SEARCH_BACKEND", "redis"),
+			"search backend, either \"redis\" or \"memory\" (env $SEARCH_BACKEND)")
+		adminToken := serverCmd.String("admin-token", os.Getenv("ADMIN_TOKEN"),
+			"bearer token for the /admin/reload endpoint (env $ADMIN_TOKEN)")
+		reloadInterval := serverCmd.Duration("reload-interval", 0,
+			"how often to reload the data file, or 0 to disable")
+		cacheSize := serverCmd.Int("cache-size", envInt("CACHE_SIZE", 200000),
+			"number of course IDs to keep in cached query results, or 0 to disable (env $CACHE_SIZE)")
+		rateLimit := serverCmd.Float64("rate-limit", envFloat("RATE_LIMIT", 50),
+			"API requests per second allowed from each client, or 0 to disable (env $RATE_LIMIT)")
+		rateBurst := serverCmd.Int("rate-burst", envInt("RATE_BURST", 100),
+			"burst size for -rate-limit (env $RATE_BURST)")
+		trustProxy := serverCmd.Bool("trust-proxy", envBool("TRUST_PROXY", false),
+			"read client addresses from Fly-Client-IP and X-Forwarded-For (env $TRUST_PROXY)")
+		maxQueryLength := serverCmd.Int("max-query-length", envInt("MAX_QUERY_LENGTH", 1000),
+			"maximum length of a search query in bytes, or 0 for none (env $MAX_QUERY_LENGTH)")
+		logFormat := serverCmd.String("log-format", envString("LOG_FORMAT", "text"),
+			"log output format, either \"text\" or \"json\" (env $LOG_FORMAT)")
+		addr := serverCmd.String("addr", envString("LISTEN_ADDR", ":7500"),
+			"address to listen on for HTTP requests (env $LISTEN_ADDR)")
+		redisPort := serverCmd.Int("redis-port", envInt("REDIS_PORT", 7501),
+			"port for the Redis subprocess (env $REDIS_PORT)")
+		redisImage := serverCmd.String("redis-image",
+			envString("REDIS_IMAGE", "redis/redis-stack-server:7.0.6-RC8"),
+			"docker image for Redis in local mode (env $REDIS_IMAGE)")
+		redisModules := serverCmd.String("redis-modules",
+			envString("REDIS_MODULES", "/opt/redis-stack/lib/redisearch.so,/opt/redis-stack/lib/rejson.so"),
+			"comma-separated paths of Redis modules to load (env $REDIS_MODULES)")
+		redisAddr := serverCmd.String("redis-addr", envString("REDIS_ADDR", ""),
+			"address of an existing Redis Stack server to use instead (env $REDIS_ADDR)")
+		redisPassword := serverCmd.String("redis-password", envString("REDIS_PASSWORD", ""),
+			"password for -redis-addr (env $REDIS_PASSWORD)")
+		redisTLS := serverCmd.Bool("redis-tls", envBool("REDIS_TLS", false),
+			"connect to -redis-addr over TLS (env $REDIS_TLS)")
+		redisNamespace := serverCmd.String("redis-namespace", envString("REDIS_NAMESPACE", ""),
+			"prefix for index names and keys in Redis, like \"classes:\", required with -redis-addr (env $REDIS_NAMESPACE)")
 		serverCmd.Parse(os.Args[2:])
 
 		if *data == "" {
 			log.Fatal("server requires a -data file")
 		}
-		server.Run(*data, *static, *local)
+		if *rateBurst < 1 {
+			// Every request would be rejected, since the bucket never fills.
+			log.Fatalf("invalid -rate-burst: %d is less than 1", *rateBurst)
+		}
+		if *redisAddr != "" && *redisNamespace == "" {
+			// Other data in the server could otherwise be mistaken for ours.
+			log.Fatal("-redis-addr requires a -redis-namespace")
+		}
+		switch *logFormat {
+		case "text":
+			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+		case "json":
+			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		default:
+			log.Fatalf("unknown log format %q", *logFormat)
+		}
+		var modules []string
+		if *redisModules != "" {
+			modules = strings.Split(*redisModules, ",")
+		}
+		err := server.Run(server.Config{
+			Data:     *data,
+			Static:   *static,
+			Local:    *local,
+			Synonyms: *synonyms,
+			Backend:  *backend,
+
+			Addr:         *addr,
+			RedisPort:    *redisPort,
+			RedisImage:   *redisImage,
+			RedisModules: modules,
+
+			RedisAddr:      *redisAddr,
+			RedisPassword:  *redisPassword,
+			RedisTLS:       *redisTLS,
+			RedisNamespace: *redisNamespace,
+
+			AdminToken:     *adminToken,
+			ReloadInterval: *reloadInterval,
+			CacheSize:      *cacheSize,
+
+			RateLimit:      *rateLimit,
+			RateBurst:      *rateBurst,
+			TrustProxy:     *trustProxy,
+			MaxQueryLength: *maxQueryLength,
+		})
+		if err != nil {
+			slog.Error("Server failed", "err", err)
+			os.Exit(1)
+		}
 
 	default:
 		log.Fatal("unexpected subcommand")
 	}
 }
+
+// Read a string from an environment variable, or return a default if unset.
+func envString(name, def string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return def
+}
+
+// Read an integer from an environment variable, or return a default if unset.
+func envInt(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid $%s: %q is not an integer", name, value)
+	}
+	return n
+}
+
+// Read a number from an environment variable, or return a default if unset.
+func envFloat(name string, def float64) float64 {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("invalid $%s: %q is not a number", name, value)
+	}
+	return f
+}
+
+// Read a boolean from an environment variable, or return a default if unset.
+func envBool(name string, def bool) bool {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid $%s: %q is not a boolean", name, value)
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+// In-memory cache of query results, in front of the search backend.
+
+package server
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// An LRU cache of search and facet results, which also coalesces identical
+// concurrent queries so that only one of them reaches the backend.
+//
+// Search-as-you-type sends the same prefixes from many clients, so even a
+// small cache absorbs a large share of queries. It must be cleared whenever
+// the backend is reindexed.
+//
+// The size of the cache is measured in course IDs and facet values, rather
+// than entries, since a single result can hold thousands of IDs.
+type queryCache struct {
+	size int // Maximum total cost of the entries.
+
+	mu      sync.Mutex
+	used    int                        // Total cost of the entries.
+	entries map[cacheKey]*list.Element // Elements of order, by key.
+	order   *list.List                 // Entries, most recently used first.
+	calls   map[cacheKey]*cacheCall    // Queries currently in flight.
+	gen     uint64                     // Incremented when the cache is cleared.
+
+	hits   uint64 // Accessed atomically.
+	misses uint64 // Accessed atomically.
+}
+
+// Identifies a query and the parameters that affect its results.
+type cacheKey struct {
+	query  string // Query with normalized whitespace.
+	sort   string
+	offset int
+	limit  int
+	facets string // Comma-separated facet fields, for facet queries only.
+}
+
+// Results of a search or facet query.
+type cacheValue struct {
+	count  int64
+	ids    []string
+	facets map[string][]facetCount
+}
+
+// Approximate memory used by a cached value, counted in IDs and facet values.
+// Every entry costs at least one, for its key.
+func (v cacheValue) cost() int {
+	n := 1 + len(v.ids)
+	for _, counts := range v.facets {
+		n += len(counts)
+	}
+	return n
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value cacheValue
+	cost  int
+}
+
+// A query in flight, which other callers with the same key wait on.
+type cacheCall struct {
+	done  chan struct{}
+	value cacheValue
+	err   error
+}
+
+func newQueryCache(size int) *queryCache {
+	return &queryCache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element),
+		order:   list.New(),
+		calls:   make(map[cacheKey]*cacheCall),
+	}
+}
+
+// Normalize whitespace in a query, which does not change its meaning.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// Return the cached results for a key, or otherwise compute them with fn. If
+// the same key is already being computed, this waits for that result instead.
+// Errors are returned to every waiting caller, but are not cached.
+//
+// The second return value reports whether the result came from the cache or
+// from another caller's query, rather than from calling fn.
+func (c *queryCache) get(key cacheKey, fn func() (cacheValue, error)) (cacheValue, bool, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return elem.Value.(*cacheEntry).value, true, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		atomic.AddUint64(&c.hits, 1)
+		return call.value, true, call.err
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+	gen := c.gen
+	c.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	call.value, call.err = fn()
+	close(call.done)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		// The cache was cleared while querying, so the result may be stale.
+		return call.value, false, call.err
+	}
+	delete(c.calls, key)
+	if cost := call.value.cost(); call.err == nil && cost <= c.size {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key, call.value, cost})
+		c.used += cost
+		for c.used > c.size {
+			oldest := c.order.Remove(c.order.Back()).(*cacheEntry)
+			delete(c.entries, oldest.key)
+			c.used -= oldest.cost
+		}
+	}
+	return call.value, false, call.err
+}
+
+// Remove every entry from the cache, after the backend has been reindexed.
+func (c *queryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+	c.used = 0
+	c.calls = make(map[cacheKey]*cacheCall)
+	c.gen++
+}
+
+// Execute a query on the backend, through the cache if it is enabled. This
+// also reports whether the result was served from the cache.
+func (s *Server) search(query, sort string, offset, limit int) (int64, []string, bool, error) {
+	if s.cache == nil {
+		count, ids, err := s.backend.search(query, sort, offset, limit)
+		return count, ids, false, err
+	}
+	key := cacheKey{query: normalizeQuery(query), sort: sort, offset: offset, limit: limit}
+	value, hit, err := s.cache.get(key, func() (cacheValue, error) {
+		count, ids, err := s.backend.search(query, sort, offset, limit)
+		return cacheValue{count: count, ids: ids}, err
+	})
+	return value.count, value.ids, hit, err
+}
+
+// Count the results of a query by field, through the cache if it is enabled.
+func (s *Server) facets(query string, fields []string) (map[string][]facetCount, error) {
+	if s.cache == nil {
+		return s.backend.facets(query, fields)
+	}
+	key := cacheKey{query: normalizeQuery(query), facets: strings.Join(fields, ",")}
+	value, _, err := s.cache.get(key, func() (cacheValue, error) {
+		facets, err := s.backend.facets(query, fields)
+		return cacheValue{facets: facets}, err
+	})
+	return value.facets, err
+}
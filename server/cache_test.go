@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQueryCacheSize(t *testing.T) {
+	c := newQueryCache(10)
+	calls := 0
+	get := func(query string, ids int) bool {
+		value := cacheValue{count: int64(ids), ids: make([]string, ids)}
+		_, hit, _ := c.get(cacheKey{query: query}, func() (cacheValue, error) {
+			calls++
+			return value, nil
+		})
+		return hit
+	}
+
+	get("a", 4) // Costs 5, with its key.
+	get("b", 4)
+	if !get("a", 4) || !get("b", 4) {
+		t.Fatal("entries within the size were evicted")
+	}
+	get("c", 0) // Evicts "a", the least recently used.
+	if c.used != 6 || !get("b", 4) {
+		t.Errorf("used = %d after eviction, want 6 with b cached", c.used)
+	}
+	if get("a", 4) {
+		t.Error("least recently used entry was not evicted")
+	}
+
+	// Results larger than the whole cache are never stored.
+	before := c.used
+	get("big", 20)
+	if get("big", 20) || c.used != before {
+		t.Errorf("oversized result was cached, used = %d", c.used)
+	}
+
+	c.clear()
+	if c.used != 0 || len(c.entries) != 0 {
+		t.Errorf("clear left used = %d, %d entries", c.used, len(c.entries))
+	}
+	if want := 6; calls != want {
+		t.Errorf("backend called %d times, want %d", calls, want)
+	}
+}
+
+func TestQueryCacheErrors(t *testing.T) {
+	c := newQueryCache(10)
+	fail := func() (cacheValue, error) { return cacheValue{}, fmt.Errorf("down") }
+	if _, hit, err := c.get(cacheKey{query: "a"}, fail); err == nil || hit {
+		t.Fatalf("get = %v, %v; want a miss with an error", hit, err)
+	}
+	if _, ok := c.entries[cacheKey{query: "a"}]; ok || c.used != 0 {
+		t.Error("error result was cached")
+	}
+}
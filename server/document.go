@@ -0,0 +1,116 @@
+package server
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"classes.wtf/datasource"
+)
+
+// Document stored in Redis for each course. This embeds the course data along
+// with extra derived fields that are only used for indexing and sorting.
+type indexDoc struct {
+	datasource.Course
+
+	// CatalogSort orders courses by subject, then by catalog number.
+	CatalogSort string `json:"catalogSort"`
+
+	// Term orders courses chronologically by semester.
+	Term uint32 `json:"term"`
+
+	// StartTime and EndTime are the earliest start and the latest end of the
+	// course's meetings on any day, in minutes since midnight. They are
+	// missing if the course has no meeting times.
+	StartTime *int `json:"startTime,omitempty"`
+	EndTime   *int `json:"endTime,omitempty"`
+
+	// Days are the days of the week that the course meets on, like "M" or
+	// "Th", abbreviated as in the frontend.
+	Days []string `json:"days"`
+}
+
+func newIndexDoc(course datasource.Course) indexDoc {
+	doc := indexDoc{
+		Course:      course,
+		CatalogSort: course.Subject + " " + padCatalogNumber(course.CatalogNumber),
+		Term:        course.AcademicYear*10 + semesterOrder(course.Semester),
+		Days:        meetingDays(course.MeetingPatterns),
+	}
+	for _, pattern := range course.MeetingPatterns {
+		start, end, ok := pattern.Times()
+		if !ok || len(pattern.Weekdays()) == 0 {
+			continue
+		}
+		if doc.StartTime == nil || start < *doc.StartTime {
+			doc.StartTime = &start
+		}
+		if doc.EndTime == nil || end > *doc.EndTime {
+			doc.EndTime = &end
+		}
+	}
+	return doc
+}
+
+// Abbreviations of the days of the week in the "days" field.
+var dayAbbrevs = [7]string{
+	time.Sunday:    "Su",
+	time.Monday:    "M",
+	time.Tuesday:   "Tu",
+	time.Wednesday: "W",
+	time.Thursday:  "Th",
+	time.Friday:    "F",
+	time.Saturday:  "S",
+}
+
+// Return the abbreviated days of the week that any of the patterns meet on,
+// in order from Monday.
+func meetingDays(patterns []datasource.MeetingPattern) []string {
+	var meets [7]bool
+	for _, pattern := range patterns {
+		for _, day := range pattern.Weekdays() {
+			meets[day] = true
+		}
+	}
+	days := []string{}
+	for i := 1; i <= 7; i++ {
+		if day := time.Weekday(i % 7); meets[day] {
+			days = append(days, dayAbbrevs[day])
+		}
+	}
+	return days
+}
+
+// Zero-pad the first run of digits in a catalog number, so that courses sort
+// in numerical order: "9" < "50A" < "124" < "S-101".
+func padCatalogNumber(number string) string {
+	start := strings.IndexFunc(number, unicode.IsDigit)
+	if start == -1 {
+		return number
+	}
+	end := start
+	for end < len(number) && unicode.IsDigit(rune(number[end])) {
+		end++
+	}
+	const width = 5
+	if end-start >= width {
+		return number
+	}
+	return number[:start] + strings.Repeat("0", width-(end-start)) + number[start:]
+}
+
+// Position of a semester within its academic year, which starts in the fall.
+func semesterOrder(semester string) uint32 {
+	switch {
+	case strings.HasPrefix(semester, "Fall"):
+		return 1
+	case strings.HasPrefix(semester, "Winter"), strings.HasPrefix(semester, "January"):
+		return 2
+	case strings.HasPrefix(semester, "Spring"):
+		return 3
+	case strings.HasPrefix(semester, "Summer"):
+		return 4
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,189 @@
+// Faceted counts of search results, grouped by field value.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
+
+	"classes.wtf/datasource"
+)
+
+// Indexed fields that can be requested as facets in a search.
+var facetFields = []string{"level", "genEdArea", "component", "academicYear", "semester", "subject"}
+
+// Maximum number of distinct values returned for any single facet.
+const maxFacetValues = 500
+
+// Values of each facet field in a course, for counting facets in Go.
+var courseFacetFields = map[string]func(course *datasource.Course) []string{
+	"level":     func(course *datasource.Course) []string { return []string{course.Level} },
+	"genEdArea": func(course *datasource.Course) []string { return course.GenEdArea },
+	"component": func(course *datasource.Course) []string { return []string{course.Component} },
+	"academicYear": func(course *datasource.Course) []string {
+		return []string{strconv.Itoa(int(course.AcademicYear))}
+	},
+	"semester": func(course *datasource.Course) []string { return []string{course.Semester} },
+	"subject":  func(course *datasource.Course) []string { return []string{course.Subject} },
+}
+
+// facetCount is the number of search results with a given field value.
+type facetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Parse a comma-separated list of facet names, checking that each is valid.
+func parseFacets(s string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !slices.Contains(facetFields, field) {
+			return nil, fmt.Errorf("unknown facet %q, expected one of %s",
+				field, strings.Join(facetFields, ", "))
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// Count the results of a query grouped by each of the given fields.
+//
+// Each field is grouped with a separate FT.AGGREGATE command, but they are sent
+// together in a single pipeline. Counts are sorted in descending order.
+func (ts *TextSearch) facets(query string, fields []string) (map[string][]facetCount, error) {
+	idx := ts.current()
+	if idx == nil {
+		return nil, errNoIndex
+	}
+	pipe := ts.rdb.Pipeline()
+	cmds := make([]*redis.Cmd, len(fields))
+	for i, field := range fields {
+		cmds[i] = pipe.Do(ts.ctx,
+			"FT.AGGREGATE", idx.name, idx.expandSynonyms(query),
+			"LOAD", "1", "@"+field,
+			"GROUPBY", "1", "@"+field, "REDUCE", "COUNT", "0", "AS", "count",
+			"SORTBY", "2", "@count", "DESC",
+			"LIMIT", "0", maxFacetValues,
+		)
+	}
+	if _, err := pipe.Exec(ts.ctx); err != nil {
+		return nil, err
+	}
+
+	facets := make(map[string][]facetCount, len(fields))
+	for i, field := range fields {
+		rows, err := cmds[i].Slice()
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("empty reply from FT.AGGREGATE")
+		}
+		counts := make(map[string]int64)
+		for _, row := range rows[1:] {
+			row, ok := row.([]any)
+			if !ok {
+				continue
+			}
+			var value string
+			var count int64
+			for j := 0; j+1 < len(row); j += 2 {
+				switch redisString(row[j]) {
+				case field:
+					value = redisString(row[j+1])
+				case "count":
+					count, _ = strconv.ParseInt(redisString(row[j+1]), 10, 64)
+				}
+			}
+			for _, v := range facetValues(value) {
+				counts[v] += count
+			}
+		}
+		facets[field] = sortFacets(counts)
+	}
+	return facets, nil
+}
+
+// Count the results of a query grouped by each of the given fields, given the
+// matching courses themselves rather than a query.
+func countFacets(courses []*datasource.Course, fields []string) (map[string][]facetCount, error) {
+	facets := make(map[string][]facetCount, len(fields))
+	for _, field := range fields {
+		values, ok := courseFacetFields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown facet %q", field)
+		}
+		counts := make(map[string]int64)
+		for _, course := range courses {
+			for _, value := range values(course) {
+				if value != "" {
+					counts[value]++
+				}
+			}
+		}
+		facets[field] = sortFacets(counts)
+	}
+	return facets, nil
+}
+
+// Sort facet values in descending order of count, then by value, keeping at
+// most maxFacetValues of them.
+func sortFacets(counts map[string]int64) []facetCount {
+	result := make([]facetCount, 0, len(counts))
+	for value, count := range counts {
+		result = append(result, facetCount{value, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	if len(result) > maxFacetValues {
+		result = result[:maxFacetValues]
+	}
+	return result
+}
+
+// Split a grouped value into its elements, since array fields like genEdArea
+// are loaded from JSON documents as a single serialized value.
+func facetValues(value string) []string {
+	if strings.HasPrefix(value, "[") {
+		var values []any
+		if err := json.Unmarshal([]byte(value), &values); err == nil {
+			strs := make([]string, 0, len(values))
+			for _, v := range values {
+				strs = append(strs, fmt.Sprint(v))
+			}
+			return strs
+		}
+	}
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// Convert a value in a Redis reply to a string.
+func redisString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
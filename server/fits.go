@@ -0,0 +1,157 @@
+// Filtering search results to courses that fit around a schedule.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"classes.wtf/datasource"
+)
+
+const (
+	// Maximum number of busy blocks that a client can give.
+	maxBusyBlocks = 100
+
+	// Maximum number of chosen courses that results can be required to fit.
+	maxFitsCourses = 50
+
+	// Maximum number of search results checked against a schedule. Queries
+	// with more results than this report a lower bound on their count.
+	maxFitScan = 10 * maxLimit
+)
+
+// Parse the days of the week in a string like "MW" or "TuTh", using the same
+// abbreviations as the "days" index field.
+func parseDays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for rest := s; rest != ""; {
+		found := false
+		// Try two-letter abbreviations first, so "Tu" isn't read as "T".
+		for _, n := range []int{2, 1} {
+			if len(rest) < n {
+				continue
+			}
+			for day, abbrev := range dayAbbrevs {
+				if abbrev == rest[:n] {
+					days = append(days, time.Weekday(day))
+					rest = rest[n:]
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid days %q", s)
+		}
+	}
+	return days, nil
+}
+
+// Parse comma-separated busy time blocks, like "MW 09:00-10:30,F 13:00-15:00",
+// into weekly meetings without date bounds.
+func parseBusy(s string) ([]datasource.Meeting, error) {
+	var meetings []datasource.Meeting
+	for _, block := range strings.Split(s, ",") {
+		block = strings.TrimSpace(block)
+		if block == "" {
+			continue
+		}
+		dayPart, timePart, ok := strings.Cut(block, " ")
+		startPart, endPart, ok2 := strings.Cut(strings.TrimSpace(timePart), "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid busy block %q, expected a form like \"MW 09:00-10:30\"", block)
+		}
+		days, err := parseDays(dayPart)
+		if err != nil {
+			return nil, err
+		}
+		start, err := datasource.ParseClock(startPart)
+		if err != nil {
+			return nil, err
+		}
+		end, err := datasource.ParseClock(endPart)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, fmt.Errorf("invalid busy block %q, which ends before it starts", block)
+		}
+		for _, day := range days {
+			meetings = append(meetings, datasource.Meeting{Day: day, Start: start, End: end})
+		}
+	}
+	if len(meetings) > maxBusyBlocks {
+		return nil, fmt.Errorf("too many busy blocks: %d is more than %d", len(meetings), maxBusyBlocks)
+	}
+	return meetings, nil
+}
+
+// Parse the "fits" and "busy" query parameters into the meetings that search
+// results must not collide with, or otherwise write an error response. The
+// "fits" parameter is a comma-separated list of chosen course IDs, and "busy"
+// is a list of blocks in the form accepted by parseBusy.
+func (s *Server) busyParams(w http.ResponseWriter, r *http.Request, cat *catalog) ([]datasource.Meeting, bool) {
+	busy, err := parseBusy(r.URL.Query().Get("busy"))
+	if err != nil {
+		s.invalidParam(w, err)
+		return nil, false
+	}
+	ids, ok := s.idsParam(w, r, "fits", maxFitsCourses)
+	if !ok {
+		return nil, false
+	}
+	courses, missing := cat.lookup(ids)
+	if len(missing) > 0 {
+		s.invalidParam(w, fmt.Errorf("invalid fits: courses not found: %s", strings.Join(missing, ", ")))
+		return nil, false
+	}
+	for _, course := range courses {
+		busy = append(busy, course.Meetings()...)
+	}
+	return busy, true
+}
+
+// Reports whether a course has no meetings that collide with the busy ones.
+func fitsAround(course datasource.Course, busy []datasource.Meeting) bool {
+	for _, m := range course.Meetings() {
+		for _, b := range busy {
+			if m.Overlaps(b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Execute a query, returning every course that fits around the busy meetings.
+// This pages through the backend's results in order, so the courses are sorted
+// like the query's results. They are incomplete if the results were truncated
+// after checking maxFitScan of them.
+func (s *Server) searchFitting(cat *catalog, query, sort string,
+	busy []datasource.Meeting) (courses []*datasource.Course, hit, truncated bool, err error) {
+	for start := 0; start < maxFitScan; start += maxLimit {
+		total, batch, batchHit, err := s.search(query, sort, start, maxLimit)
+		if err != nil {
+			return nil, false, false, err
+		}
+		if start == 0 {
+			hit = batchHit
+		}
+		for _, id := range batch {
+			course, ok := cat.vals[id]
+			if ok && fitsAround(course, busy) {
+				courses = append(courses, &course)
+			}
+		}
+		if int64(start+len(batch)) >= total || len(batch) == 0 {
+			return courses, hit, false, nil
+		}
+	}
+	return courses, hit, true, nil
+}
@@ -0,0 +1,319 @@
+// Generating conflict-free schedules from a wishlist of courses.
+
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"classes.wtf/datasource"
+)
+
+const (
+	// Maximum number of courses in a wishlist.
+	maxWishlist = 12
+
+	// Maximum number of partial schedules to explore for one request, which
+	// bounds the running time of the search.
+	maxGenerateSteps = 200000
+
+	// Number of schedules returned when the client does not ask.
+	defaultSchedules = 10
+
+	// Maximum number of schedules that a client can request.
+	maxSchedules = 50
+
+	// Meetings that start before this time, in minutes, are early mornings.
+	earlyMorning = 10 * 60
+)
+
+// Identifies an offering of a course in a semester, which may have several
+// sections that students can choose between.
+type offeringKey struct {
+	externalId uint32
+	semester   string
+	component  string
+}
+
+func offeringOf(course datasource.Course) offeringKey {
+	return offeringKey{course.ExternalId, course.Semester, course.Component}
+}
+
+// Index the IDs of the sections of each offering, in sorted order.
+func indexOfferings(data []datasource.Course) map[offeringKey][]string {
+	offerings := make(map[offeringKey][]string)
+	for _, course := range data {
+		key := offeringOf(course)
+		offerings[key] = append(offerings[key], course.Id)
+	}
+	for _, ids := range offerings {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return offerings
+}
+
+// A course that the student would like to take.
+type wish struct {
+	id       string
+	required bool
+	sections []section // Sections to choose between.
+}
+
+// A section of a course, with its weekly meetings.
+type section struct {
+	id       string
+	meetings []datasource.Meeting
+}
+
+// Preferences used to rank the generated schedules.
+type schedulePrefs struct {
+	NoEarlyMornings bool `json:"noEarlyMornings"` // Avoid meetings before 10:00.
+	FreeFridays     bool `json:"freeFridays"`     // Avoid meetings on Fridays.
+}
+
+// A conflict-free choice of sections for some of the wished-for courses.
+type generatedSchedule struct {
+	Courses        []string `json:"courses"` // IDs of the chosen sections.
+	Skipped        []string `json:"skipped"` // Wishlist IDs left out.
+	Score          int      `json:"score"`
+	EarlyMornings  int      `json:"earlyMornings"`
+	FridayMeetings int      `json:"fridayMeetings"`
+
+	key string // Chosen IDs joined by commas, to break ties consistently.
+}
+
+// Reports whether a schedule ranks before another.
+func (a *generatedSchedule) better(b *generatedSchedule) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.key < b.key
+}
+
+// The best schedules found so far, as a heap with the worst of them on top.
+type scheduleHeap []generatedSchedule
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[j].better(&h[i]) }
+func (h scheduleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scheduleHeap) Push(x any)        { *h = append(*h, x.(generatedSchedule)) }
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// A backtracking search for conflict-free schedules.
+type scheduleSearch struct {
+	wishes    []wish
+	prefs     schedulePrefs
+	chosen    []*section // Chosen section of each wish so far, or nil.
+	steps     int
+	truncated bool
+	limit     int          // Number of schedules to keep.
+	count     int          // Number of schedules found.
+	best      scheduleHeap // Best schedules found, up to the limit.
+}
+
+// Search for schedules of all the wishes, returning the best from best to worst.
+func (g *scheduleSearch) run() []generatedSchedule {
+	// Search the most constrained courses first, to prune conflicts early.
+	sort.SliceStable(g.wishes, func(i, j int) bool {
+		a, b := g.wishes[i], g.wishes[j]
+		if a.required != b.required {
+			return a.required
+		}
+		return len(a.sections) < len(b.sections)
+	})
+	g.chosen = make([]*section, len(g.wishes))
+	g.search(0)
+	sort.Slice(g.best, func(i, j int) bool { return g.best[i].better(&g.best[j]) })
+	return g.best
+}
+
+// Choose a section for each wish starting from i, or skip it if optional.
+func (g *scheduleSearch) search(i int) {
+	if g.steps++; g.steps > maxGenerateSteps {
+		g.truncated = true
+		return
+	}
+	if i == len(g.wishes) {
+		g.record()
+		return
+	}
+	for j := range g.wishes[i].sections {
+		sec := &g.wishes[i].sections[j]
+		if !g.conflicts(sec) {
+			g.chosen[i] = sec
+			g.search(i + 1)
+			g.chosen[i] = nil
+		}
+	}
+	if !g.wishes[i].required {
+		g.search(i + 1)
+	}
+}
+
+// Check whether a section meets at the same time as any chosen section.
+func (g *scheduleSearch) conflicts(sec *section) bool {
+	for _, other := range g.chosen {
+		if other == nil {
+			continue
+		}
+		for _, a := range sec.meetings {
+			for _, b := range other.meetings {
+				if a.Overlaps(b) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Score the chosen sections, and keep them if they are among the best found.
+func (g *scheduleSearch) record() {
+	var schedule generatedSchedule
+	courses := 0
+	for _, sec := range g.chosen {
+		if sec == nil {
+			continue
+		}
+		courses++
+		for _, m := range sec.meetings {
+			if m.Start < earlyMorning {
+				schedule.EarlyMornings++
+			}
+			if m.Day == time.Friday {
+				schedule.FridayMeetings++
+			}
+		}
+	}
+	if courses == 0 {
+		return
+	}
+	g.count++
+	// Taking more of the wishlist always matters more than the preferences.
+	schedule.Score = 100 * courses
+	if g.prefs.NoEarlyMornings {
+		schedule.Score -= 10 * schedule.EarlyMornings
+	}
+	if g.prefs.FreeFridays {
+		schedule.Score -= 10 * schedule.FridayMeetings
+	}
+	full := len(g.best) >= g.limit
+	if g.limit == 0 || full && schedule.Score < g.best[0].Score {
+		return // Skip building the lists when the schedule can't be kept.
+	}
+
+	schedule.Courses = make([]string, 0, courses)
+	schedule.Skipped = make([]string, 0, len(g.chosen)-courses)
+	for i, sec := range g.chosen {
+		if sec == nil {
+			schedule.Skipped = append(schedule.Skipped, g.wishes[i].id)
+		} else {
+			schedule.Courses = append(schedule.Courses, sec.id)
+		}
+	}
+	schedule.key = strings.Join(schedule.Courses, ",")
+	if !full {
+		heap.Push(&g.best, schedule)
+	} else if schedule.better(&g.best[0]) {
+		g.best[0] = schedule
+		heap.Fix(&g.best, 0)
+	}
+}
+
+// Serves generated schedules at "/schedule/generate". This takes a POST
+// request with a JSON body like:
+//
+//	{
+//	  "semester": "Fall 2023",
+//	  "courses": [{"id": "a", "required": true}, {"id": "b"}],
+//	  "preferences": {"noEarlyMornings": true, "freeFridays": true},
+//	  "limit": 10
+//	}
+//
+// Each course can be any offering of a course, and its sections in the given
+// semester are chosen between. The semester defaults to that of each course.
+// Schedules are returned from best to worst.
+func (s *Server) serveGenerate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Semester string `json:"semester"`
+		Courses  []struct {
+			Id       string `json:"id"`
+			Required bool   `json:"required"`
+		} `json:"courses"`
+		Preferences schedulePrefs `json:"preferences"`
+		Limit       *int          `json:"limit"`
+	}
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if len(req.Courses) == 0 || len(req.Courses) > maxWishlist {
+		s.invalidParam(w, fmt.Errorf("invalid courses: must have between 1 and %d", maxWishlist))
+		return
+	}
+	limit := defaultSchedules
+	if req.Limit != nil {
+		if limit = *req.Limit; limit < 0 || limit > maxSchedules {
+			s.invalidParam(w, fmt.Errorf("invalid limit: %d is not between 0 and %d", limit, maxSchedules))
+			return
+		}
+	}
+	cat := s.loadedCatalog(w)
+	if cat == nil {
+		return
+	}
+
+	g := &scheduleSearch{prefs: req.Preferences, limit: limit, best: make(scheduleHeap, 0, limit)}
+	missing := []string{}
+	missingRequired := false
+	seen := make(map[offeringKey]int) // Index of the wish for each offering.
+	for _, item := range req.Courses {
+		course, ok := cat.vals[item.Id]
+		if !ok {
+			missing = append(missing, item.Id)
+			missingRequired = missingRequired || item.Required
+			continue
+		}
+		key := offeringOf(course)
+		if req.Semester != "" {
+			key.semester = req.Semester
+		}
+		if i, ok := seen[key]; ok {
+			g.wishes[i].required = g.wishes[i].required || item.Required
+			continue
+		}
+		sections := []section{}
+		for _, id := range cat.offerings[key] {
+			sec := cat.vals[id]
+			sections = append(sections, section{id, sec.Meetings()})
+		}
+		if len(sections) == 0 {
+			missing = append(missing, item.Id) // Not offered in this semester.
+			missingRequired = missingRequired || item.Required
+			continue
+		}
+		seen[key] = len(g.wishes)
+		g.wishes = append(g.wishes, wish{item.Id, item.Required, sections})
+	}
+
+	schedules := []generatedSchedule{}
+	if !missingRequired && len(g.wishes) > 0 {
+		schedules = g.run()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"count":     g.count,
+		"schedules": schedules,
+		"missing":   missing,
+		"truncated": g.truncated,
+	})
+}
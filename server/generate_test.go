@@ -0,0 +1,98 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"classes.wtf/datasource"
+)
+
+// A section meeting on one day, with times like "10:00".
+func testSection(id string, day time.Weekday, start, end string) section {
+	m := datasource.Meeting{Day: day}
+	m.Start, _ = datasource.ParseClock(start)
+	m.End, _ = datasource.ParseClock(end)
+	return section{id, []datasource.Meeting{m}}
+}
+
+// Return the chosen and skipped IDs of each schedule.
+func scheduleIds(schedules []generatedSchedule) [][2][]string {
+	ids := make([][2][]string, len(schedules))
+	for i, schedule := range schedules {
+		ids[i] = [2][]string{schedule.Courses, schedule.Skipped}
+	}
+	return ids
+}
+
+func TestGenerateRequired(t *testing.T) {
+	g := &scheduleSearch{limit: 10, wishes: []wish{
+		{"b", false, []section{testSection("b", time.Monday, "10:30", "11:30")}},
+		{"c", false, []section{testSection("c", time.Tuesday, "10:00", "11:00")}},
+		{"a", true, []section{testSection("a", time.Monday, "10:00", "11:00")}},
+	}}
+	got := scheduleIds(g.run())
+	// The required course is searched first, and conflicts with "b".
+	want := [][2][]string{
+		{{"a", "c"}, {"b"}},
+		{{"a"}, {"b", "c"}},
+	}
+	if !reflect.DeepEqual(got, want) || g.count != 2 {
+		t.Errorf("schedules = %v, count %d; want %v, count 2", got, g.count, want)
+	}
+}
+
+func TestGenerateSections(t *testing.T) {
+	g := &scheduleSearch{limit: 10, wishes: []wish{
+		{"a", true, []section{
+			testSection("a1", time.Monday, "10:00", "11:00"),
+			testSection("a2", time.Wednesday, "10:00", "11:00"),
+			testSection("a3", time.Monday, "09:00", "10:00"),
+		}},
+		{"b", true, []section{testSection("b", time.Monday, "10:00", "11:00")}},
+	}}
+	got := scheduleIds(g.run())
+	// Sections that only touch the other course's meeting don't conflict.
+	want := [][2][]string{
+		{{"b", "a2"}, {}},
+		{{"b", "a3"}, {}},
+	}
+	if !reflect.DeepEqual(got, want) || g.count != 2 {
+		t.Errorf("schedules = %v, count %d; want %v, count 2", got, g.count, want)
+	}
+}
+
+func TestGenerateLimit(t *testing.T) {
+	wishes := func() []wish {
+		return []wish{
+			{"a", false, []section{testSection("a", time.Monday, "10:00", "11:00")}},
+			{"b", false, []section{testSection("b", time.Tuesday, "10:00", "11:00")}},
+			{"c", false, []section{testSection("c", time.Friday, "10:00", "11:00")}},
+			{"d", false, []section{testSection("d", time.Monday, "08:00", "09:00")}},
+		}
+	}
+	tests := []struct {
+		prefs schedulePrefs
+		limit int
+		want  []string // Chosen IDs of each schedule, joined by commas.
+	}{
+		// More courses always rank first, then ties are broken by IDs.
+		{schedulePrefs{}, 4, []string{"a,b,c,d", "a,b,c", "a,b,d", "a,c,d"}},
+		{schedulePrefs{}, 1, []string{"a,b,c,d"}},
+		{schedulePrefs{FreeFridays: true}, 3, []string{"a,b,c,d", "a,b,d", "a,b,c"}},
+		{schedulePrefs{NoEarlyMornings: true, FreeFridays: true}, 3, []string{"a,b,c,d", "a,b,c", "a,b,d"}},
+		{schedulePrefs{}, 0, []string{}},
+	}
+	for _, tt := range tests {
+		g := &scheduleSearch{prefs: tt.prefs, limit: tt.limit, wishes: wishes()}
+		got := []string{}
+		for _, schedule := range g.run() {
+			got = append(got, schedule.key)
+		}
+		// Every non-empty subset fits, whether or not it is kept.
+		if !reflect.DeepEqual(got, tt.want) || g.count != 15 {
+			t.Errorf("prefs %+v, limit %d: schedules = %v, count %d; want %v, count 15",
+				tt.prefs, tt.limit, got, g.count, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Health and readiness checks, for load balancers and orchestrators.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Serves a liveness check at "/healthz", which succeeds while the process is
+// able to handle requests at all.
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+	})
+}
+
+// Serves a readiness check at "/readyz". This succeeds only when the search
+// backend is reachable and its index holds every course in the loaded data,
+// so that traffic is not routed to a replica that cannot answer queries.
+func (s *Server) serveReady(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	resp := map[string]any{}
+	notReady := func(reason string) {
+		status = http.StatusServiceUnavailable
+		resp["error"] = reason
+	}
+
+	cat := s.current()
+	docs, err := s.backend.documents()
+	switch {
+	case cat == nil:
+		notReady("course data is still loading")
+	case atomic.LoadInt32(&s.degraded) != 0:
+		notReady("search is degraded while the index is rebuilt")
+	case err != nil:
+		notReady(fmt.Sprintf("search backend is unreachable: %v", err))
+	case docs == 0:
+		notReady("search index is empty")
+	case docs != int64(len(cat.vals)):
+		notReady(fmt.Sprintf("search index has %d courses, expected %d", docs, len(cat.vals)))
+	}
+
+	resp["ready"] = status == http.StatusOK
+	resp["documents"] = docs
+	if cat != nil {
+		resp["courses"] = len(cat.vals)
+		resp["version"] = cat.version
+		resp["loaded"] = cat.loaded.UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
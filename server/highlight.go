@@ -0,0 +1,128 @@
+// Highlighting of matched query terms in search results.
+
+package server
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"classes.wtf/datasource"
+)
+
+const (
+	// Number of words of the description included in a snippet.
+	snippetWords = 30
+
+	// Number of words of context shown before the first match in a snippet.
+	snippetLead = 8
+)
+
+// Highlighted fields of a course, as HTML with matches wrapped in <mark> tags.
+type highlight struct {
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+func (cat *catalog) highlight(course datasource.Course, terms []queryTerm) highlight {
+	return highlight{
+		Title:   markTerms(course.Title, terms),
+		Snippet: markTerms(snippet(cat.descriptions[course.Id], terms), terms),
+	}
+}
+
+// Take a short window of text around the first word that matches a term, or
+// from the start of the text if there are no matches.
+func snippet(text string, terms []queryTerm) string {
+	words := strings.Fields(text)
+	first := 0
+	for i, word := range words {
+		if containsMatch(word, terms) {
+			first = i
+			break
+		}
+	}
+	start := first - snippetLead
+	if start < 0 || len(words) <= snippetWords {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+	s := strings.Join(words[start:end], " ")
+	if start > 0 {
+		s = "… " + s
+	}
+	if end < len(words) {
+		s += " …"
+	}
+	return s
+}
+
+// Check if any of the words within a whitespace-delimited field match a term.
+func containsMatch(field string, terms []queryTerm) bool {
+	for _, word := range splitWords(field) {
+		if matchesTerm(word, terms) {
+			return true
+		}
+	}
+	return false
+}
+
+// Escape text as HTML, wrapping each word that matches a term in <mark> tags.
+func markTerms(text string, terms []queryTerm) string {
+	var sb strings.Builder
+	for len(text) > 0 {
+		n := 0
+		for n < len(text) {
+			r, size := utf8.DecodeRuneInString(text[n:])
+			if !isWordRune(r) {
+				break
+			}
+			n += size
+		}
+		if n == 0 {
+			// Copy over the separators between words.
+			for n < len(text) {
+				r, size := utf8.DecodeRuneInString(text[n:])
+				if isWordRune(r) {
+					break
+				}
+				n += size
+			}
+			sb.WriteString(html.EscapeString(text[:n]))
+		} else if matchesTerm(text[:n], terms) {
+			sb.WriteString("<mark>" + html.EscapeString(text[:n]) + "</mark>")
+		} else {
+			sb.WriteString(html.EscapeString(text[:n]))
+		}
+		text = text[n:]
+	}
+	return sb.String()
+}
+
+func matchesTerm(word string, terms []queryTerm) bool {
+	word = strings.ToLower(word)
+	for _, term := range terms {
+		if term.prefix && strings.HasPrefix(word, term.word) || stem(word) == stem(term.word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Crudely remove common English suffixes, to approximate the stemming done by
+// the search index when matching words like "algorithm" and "algorithms".
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(word) > len(suffix)+3 && strings.HasSuffix(word, suffix) {
+			word = word[:len(word)-len(suffix)]
+			break
+		}
+	}
+	if len(word) > 4 {
+		word = strings.TrimSuffix(word, "e")
+	}
+	return word
+}
@@ -0,0 +1,128 @@
+// Structured access logging, with an ID for each request.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Details about a request that handlers fill in for its access log entry.
+type requestInfo struct {
+	id      string
+	query   string // Search query, if any.
+	results int64  // Number of results, if there was a query.
+	cache   string // Either "hit" or "miss", if the query cache was used.
+}
+
+type requestInfoKey struct{}
+
+// Return the access log details for a request. Handlers can update these,
+// and they are logged after the handler returns.
+func requestInfoFrom(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{} // Not logged, since this request isn't wrapped.
+}
+
+// Record a search query and its results in the access log.
+func (info *requestInfo) setQuery(query string, results int64) {
+	info.query = query
+	info.results = results
+}
+
+// Record whether a query was served from the cache in the access log.
+func (info *requestInfo) setCache(hit bool) {
+	if hit {
+		info.cache = "hit"
+	} else {
+		info.cache = "miss"
+	}
+}
+
+// Wraps a ResponseWriter to remember the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Paths that are requested frequently by monitoring, which are only logged at
+// the debug level.
+var quietPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// Wrap a handler to assign each request an ID and log it when finished.
+func (s *Server) logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: s.requestID(r)}
+		w.Header().Set("X-Request-Id", info.id)
+		rec := &statusRecorder{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		h.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("id", info.id),
+			slog.String("client", s.clientIP(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if info.query != "" {
+			attrs = append(attrs, slog.String("query", info.query), slog.Int64("results", info.results))
+		}
+		if info.cache != "" {
+			attrs = append(attrs, slog.String("cache", info.cache))
+		}
+		level := slog.LevelInfo
+		if quietPaths[r.URL.Path] {
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// Return an ID for a request, using the one set by a trusted proxy if any.
+func (s *Server) requestID(r *http.Request) string {
+	if s.trustProxy {
+		if id := r.Header.Get("X-Request-Id"); id != "" {
+			return id
+		}
+		if id := r.Header.Get("Fly-Request-Id"); id != "" {
+			return id
+		}
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
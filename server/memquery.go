@@ -0,0 +1,552 @@
+// Parsing and evaluation of queries for the in-process search backend.
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Matching documents of a query, with their relevance scores.
+type docScores map[int32]float64
+
+// A node in the syntax tree of a parsed query.
+type memNode interface {
+	eval(ix *memIndex) docScores
+}
+
+type (
+	// Documents matching all positive children, and none of the negated ones.
+	andNode struct{ children []memNode }
+
+	// Documents matching any of the children.
+	orNode struct{ children []memNode }
+
+	// Documents not matching the child.
+	notNode struct{ child memNode }
+
+	// Documents matching the child, which only boosts scores within an and.
+	optionalNode struct{ child memNode }
+
+	// Every document in the index.
+	allNode struct{}
+
+	// Documents containing a word in any of the fields.
+	termNode struct {
+		fields []int // Indices into memTextFields.
+		word   string
+		prefix bool
+		fuzzy  int // Maximum edit distance, if this is a fuzzy term.
+	}
+
+	// Documents containing a sequence of words in one of the fields.
+	phraseNode struct {
+		fields []int
+		words  []string
+	}
+
+	// Documents with any of the values in a tag field.
+	tagNode struct {
+		field  string
+		values []string
+	}
+
+	// Documents with a value of a numeric field in a range.
+	rangeNode struct {
+		field                      string
+		min, max                   float64
+		minExclusive, maxExclusive bool
+	}
+)
+
+// Parse and evaluate a query against the index. Synonyms of single words are
+// expanded while parsing, and those of phrases are expanded beforehand.
+func (ix *memIndex) query(query string) (docScores, error) {
+	query = expandQuery(query, ix.phrases)
+	p := &memParser{ix: ix, tokens: lexQuery(query)}
+	node, err := p.parseAnd(nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("syntax error at offset %d near %q",
+			p.tokens[p.pos].start, p.tokens[p.pos].text)
+	}
+	return node.eval(ix), nil
+}
+
+type memParser struct {
+	ix     *memIndex
+	tokens []token
+	pos    int
+}
+
+func (p *memParser) peek() (token, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return token{}, false
+}
+
+func (p *memParser) peekPunct(text string) bool {
+	tok, ok := p.peek()
+	return ok && tok.kind == tokenPunct && tok.text == text
+}
+
+// Parse an intersection of expressions, until the end of a group. The fields
+// are the text fields that terms apply to, or nil for all of them.
+func (p *memParser) parseAnd(fields []int) (memNode, error) {
+	var children []memNode
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokenPunct && tok.text == ")" {
+			break
+		}
+		if tok.kind == tokenPunct && !strings.Contains("-~(*%", tok.text) {
+			p.pos++ // Other punctuation just separates words.
+			continue
+		}
+		child, err := p.parseOr(fields)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			children = append(children, child)
+		}
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return andNode{children}, nil
+}
+
+// Parse a union of expressions, separated by "|".
+func (p *memParser) parseOr(fields []int) (memNode, error) {
+	node, err := p.parseUnary(fields)
+	if err != nil {
+		return nil, err
+	}
+	children := []memNode{}
+	if node != nil {
+		children = append(children, node)
+	}
+	for p.peekPunct("|") {
+		p.pos++
+		node, err := p.parseUnary(fields)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			children = append(children, node)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil, nil
+	case 1:
+		return children[0], nil
+	default:
+		return orNode{children}, nil
+	}
+}
+
+// Parse an expression that may be negated with "-" or made optional with "~".
+func (p *memParser) parseUnary(fields []int) (memNode, error) {
+	if p.peekPunct("-") || p.peekPunct("~") {
+		op := p.tokens[p.pos].text
+		p.pos++
+		child, err := p.parseUnary(fields)
+		if err != nil || child == nil {
+			return nil, err
+		}
+		if op == "-" {
+			return notNode{child}, nil
+		}
+		return optionalNode{child}, nil
+	}
+	node, err := p.parseAtom(fields)
+	if err != nil {
+		return nil, err
+	}
+	// Skip query attributes like "=> { $weight: 2.0 }", which are ignored.
+	if p.peekPunct("=") && p.pos+2 < len(p.tokens) &&
+		p.tokens[p.pos+1].text == ">" && p.tokens[p.pos+2].kind == tokenTag {
+		p.pos += 3
+	}
+	return node, nil
+}
+
+// Parse a single term, phrase, filter, or parenthesized group.
+func (p *memParser) parseAtom(fields []int) (memNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("syntax error: unexpected end of query")
+	}
+	p.pos++
+	switch tok.kind {
+	case tokenField:
+		return p.parseField(tok)
+
+	case tokenWord:
+		return p.termOrPhrase(fields, memWords(tok.text), tok.prefix), nil
+
+	case tokenPhrase:
+		words := p.withoutStopWords(memWords(tok.text))
+		if len(words) == 0 {
+			return nil, nil
+		}
+		return phraseNode{p.fieldsOrAll(fields), words}, nil
+
+	case tokenTag, tokenRange:
+		return nil, fmt.Errorf("syntax error at offset %d: %q must follow a field", tok.start, tok.text)
+
+	case tokenPunct:
+		switch tok.text {
+		case "(":
+			node, err := p.parseAnd(fields)
+			if err != nil {
+				return nil, err
+			}
+			if !p.peekPunct(")") {
+				return nil, fmt.Errorf("syntax error: missing closing parenthesis")
+			}
+			p.pos++
+			return node, nil
+		case "*":
+			return allNode{}, nil
+		case "%":
+			return p.parseFuzzy(fields)
+		}
+	}
+	return nil, fmt.Errorf("syntax error at offset %d near %q", tok.start, tok.text)
+}
+
+// Parse the expression after a field modifier like "@title:".
+func (p *memParser) parseField(tok token) (memNode, error) {
+	names := strings.Split(strings.TrimSuffix(tok.text, ":"), "|")
+	next, ok := p.peek()
+	if ok && len(names) == 1 && next.kind == tokenTag {
+		if _, ok := memTagFields[names[0]]; !ok {
+			return nil, fmt.Errorf("%q is not a tag field", names[0])
+		}
+		p.pos++
+		var values []string
+		for _, value := range strings.Split(next.text, "|") {
+			value = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(value, "\\", "")))
+			values = append(values, value)
+		}
+		return tagNode{names[0], values}, nil
+	}
+	if ok && len(names) == 1 && next.kind == tokenRange {
+		if _, ok := memNumericFields[names[0]]; !ok {
+			return nil, fmt.Errorf("%q is not a numeric field", names[0])
+		}
+		p.pos++
+		return parseRange(names[0], next.text)
+	}
+
+	var fields []int
+	for _, name := range names {
+		found := false
+		for f, field := range memTextFields {
+			if field.name == name {
+				fields = append(fields, f)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown text field %q", name)
+		}
+	}
+	return p.parseUnary(fields)
+}
+
+// Parse a fuzzy term like "%word%", where each pair of "%" allows one edit.
+func (p *memParser) parseFuzzy(fields []int) (memNode, error) {
+	dist := 1
+	for p.peekPunct("%") {
+		p.pos++
+		dist++
+	}
+	tok, ok := p.peek()
+	if !ok || tok.kind != tokenWord {
+		return nil, fmt.Errorf("syntax error: expected a word after %%")
+	}
+	p.pos++
+	for i := 0; i < dist; i++ {
+		if !p.peekPunct("%") {
+			return nil, fmt.Errorf("syntax error: unbalanced %% in fuzzy term")
+		}
+		p.pos++
+	}
+	if dist > 3 {
+		return nil, fmt.Errorf("fuzzy terms can have at most 3 edits")
+	}
+	return termNode{fields: p.fieldsOrAll(fields), word: strings.ToLower(tok.text), fuzzy: dist}, nil
+}
+
+// Build a node for a word from the query, which may have been split into
+// several words by the tokenizer, expanding synonyms of single words.
+func (p *memParser) termOrPhrase(fields []int, words []string, prefix bool) memNode {
+	fields = p.fieldsOrAll(fields)
+	if !prefix {
+		words = p.withoutStopWords(words)
+	}
+	switch {
+	case len(words) == 0:
+		return nil
+	case len(words) > 1:
+		return phraseNode{fields, words}
+	case prefix:
+		return termNode{fields: fields, word: words[0], prefix: true}
+	}
+
+	node := memNode(termNode{fields: fields, word: words[0]})
+	if synonyms := p.ix.synonyms[words[0]]; len(synonyms) > 0 {
+		children := []memNode{node}
+		for _, synonym := range synonyms {
+			if strings.Contains(synonym, " ") {
+				children = append(children, phraseNode{fields, strings.Fields(synonym)})
+			} else {
+				children = append(children, termNode{fields: fields, word: synonym})
+			}
+		}
+		node = orNode{children}
+	}
+	return node
+}
+
+func (p *memParser) withoutStopWords(words []string) []string {
+	var result []string
+	for _, word := range words {
+		if !memStopWords[word] {
+			result = append(result, word)
+		}
+	}
+	return result
+}
+
+func (p *memParser) fieldsOrAll(fields []int) []int {
+	if fields != nil {
+		return fields
+	}
+	all := make([]int, len(memTextFields))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// Parse a numeric range like "2020 2023", "(2020 +inf", or "-inf 2020".
+func parseRange(field, text string) (memNode, error) {
+	parts := strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid numeric range [%s]", text)
+	}
+	node := rangeNode{field: field}
+	bounds := []*float64{&node.min, &node.max}
+	exclusive := []*bool{&node.minExclusive, &node.maxExclusive}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "(") {
+			*exclusive[i] = true
+			part = part[1:]
+		}
+		switch strings.ToLower(part) {
+		case "inf", "+inf":
+			*bounds[i] = math.Inf(1)
+		case "-inf":
+			*bounds[i] = math.Inf(-1)
+		default:
+			value, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q in range [%s]", part, text)
+			}
+			*bounds[i] = value
+		}
+	}
+	return node, nil
+}
+
+func (n andNode) eval(ix *memIndex) docScores {
+	var result docScores
+	var negated, optional []memNode
+	for _, child := range n.children {
+		switch child := child.(type) {
+		case notNode:
+			negated = append(negated, child.child)
+		case optionalNode:
+			optional = append(optional, child.child)
+		default:
+			scores := child.eval(ix)
+			if result == nil {
+				result = scores
+				continue
+			}
+			for doc, score := range result {
+				if other, ok := scores[doc]; ok {
+					result[doc] = score + other
+				} else {
+					delete(result, doc)
+				}
+			}
+		}
+	}
+	if result == nil {
+		switch {
+		case len(negated) > 0:
+			result = allNode{}.eval(ix)
+		case len(optional) > 0:
+			// With only optional children, they are required after all.
+			return orNode{optional}.eval(ix)
+		default:
+			return docScores{}
+		}
+	}
+	for _, child := range negated {
+		for doc := range child.eval(ix) {
+			delete(result, doc)
+		}
+	}
+	for _, child := range optional {
+		for doc, score := range child.eval(ix) {
+			if _, ok := result[doc]; ok {
+				result[doc] += score
+			}
+		}
+	}
+	return result
+}
+
+func (n orNode) eval(ix *memIndex) docScores {
+	result := docScores{}
+	for _, child := range n.children {
+		if opt, ok := child.(optionalNode); ok {
+			child = opt.child
+		}
+		for doc, score := range child.eval(ix) {
+			result[doc] += score
+		}
+	}
+	return result
+}
+
+func (n notNode) eval(ix *memIndex) docScores {
+	return andNode{[]memNode{n}}.eval(ix)
+}
+
+func (n optionalNode) eval(ix *memIndex) docScores {
+	return n.child.eval(ix)
+}
+
+func (allNode) eval(ix *memIndex) docScores {
+	result := make(docScores, len(ix.docs))
+	for i := range ix.docs {
+		result[int32(i)] = 0
+	}
+	return result
+}
+
+// List the indexed words that a term matches in a field.
+func (n termNode) variants(ix *memIndex, field int) []string {
+	switch {
+	case n.prefix:
+		return ix.withPrefix(n.word)
+	case n.fuzzy > 0:
+		return ix.withinDistance(n.word, n.fuzzy)
+	case memTextFields[field].stem:
+		return ix.stems[stem(n.word)]
+	default:
+		return []string{n.word}
+	}
+}
+
+func (n termNode) eval(ix *memIndex) docScores {
+	result := docScores{}
+	for _, f := range n.fields {
+		weight := memTextFields[f].weight
+		for _, word := range n.variants(ix, f) {
+			idf := ix.idf(word)
+			for _, post := range ix.postings[f][word] {
+				result[post.doc] += weight * (1 + math.Log(float64(post.freq))) * idf
+			}
+		}
+	}
+	return result
+}
+
+func (n phraseNode) eval(ix *memIndex) docScores {
+	result := docScores{}
+	for _, f := range n.fields {
+		// Find documents with every word in this field, then check the order.
+		var candidates docScores
+		for _, word := range n.words {
+			scores := termNode{fields: []int{f}, word: word}.eval(ix)
+			if candidates == nil {
+				candidates = scores
+				continue
+			}
+			for doc, score := range candidates {
+				if other, ok := scores[doc]; ok {
+					candidates[doc] = score + other
+				} else {
+					delete(candidates, doc)
+				}
+			}
+		}
+		for doc, score := range candidates {
+			if ix.containsPhrase(doc, f, n.words) {
+				result[doc] += score
+			}
+		}
+	}
+	return result
+}
+
+// Check whether a field of a document contains a sequence of words, ignoring
+// stop words and matching stems in stemmed fields. This reads the words that
+// were saved at index time, so documents don't need to be tokenized again.
+func (ix *memIndex) containsPhrase(doc int32, field int, words []string) bool {
+	ids := make([]int32, len(words))
+	for j, word := range words {
+		id, ok := ix.phraseIds[phraseWord(field, word)]
+		if !ok {
+			return false
+		}
+		ids[j] = id
+	}
+	text := ix.phraseText[doc][field]
+search:
+	for i := 0; i+len(ids) <= len(text); i++ {
+		for j, id := range ids {
+			if text[i+j] != id {
+				continue search
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (n tagNode) eval(ix *memIndex) docScores {
+	result := docScores{}
+	for _, value := range n.values {
+		for _, doc := range ix.tags[n.field][value] {
+			result[doc] = 0
+		}
+	}
+	return result
+}
+
+func (n rangeNode) eval(ix *memIndex) docScores {
+	result := docScores{}
+	for i, value := range ix.numbers[n.field] {
+		if math.IsNaN(value) || value < n.min || value > n.max ||
+			n.minExclusive && value == n.min || n.maxExclusive && value == n.max {
+			continue
+		}
+		result[int32(i)] = 0
+	}
+	return result
+}
@@ -0,0 +1,348 @@
+// Search backend using a pure-Go inverted index held in memory.
+
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"classes.wtf/datasource"
+)
+
+// A text field in the in-process index. These mirror the TEXT fields in the
+// RediSearch schema, and should be kept in sync with it.
+type memTextField struct {
+	name   string
+	weight float64
+	stem   bool // Whether words are matched after stemming.
+	values func(doc *indexDoc) []string
+}
+
+var memTextFields = []memTextField{
+	{"title", 2, true, func(doc *indexDoc) []string {
+		return []string{doc.Title}
+	}},
+	{"description", 1, true, func(doc *indexDoc) []string {
+		return []string{datasource.PlainText(doc.Description)}
+	}},
+	{"subject", 2, false, func(doc *indexDoc) []string {
+		return []string{doc.Subject}
+	}},
+	{"number", 2, false, func(doc *indexDoc) []string {
+		return []string{doc.CatalogNumber}
+	}},
+	{"semester", 1, true, func(doc *indexDoc) []string {
+		return []string{doc.Semester}
+	}},
+	{"instructor", 1, false, func(doc *indexDoc) []string {
+		names := make([]string, len(doc.Instructors))
+		for i, instructor := range doc.Instructors {
+			names[i] = instructor.Name
+		}
+		return names
+	}},
+}
+
+// Values of the TAG fields in the RediSearch schema.
+var memTagFields = map[string]func(doc *indexDoc) []string{
+	"component":   func(doc *indexDoc) []string { return []string{doc.Component} },
+	"level":       func(doc *indexDoc) []string { return []string{doc.Level} },
+	"genEdArea":   func(doc *indexDoc) []string { return doc.GenEdArea },
+	"catalogSort": func(doc *indexDoc) []string { return []string{doc.CatalogSort} },
+	"days":        func(doc *indexDoc) []string { return doc.Days },
+}
+
+// Values of the NUMERIC fields in the RediSearch schema, or NaN if missing.
+var memNumericFields = map[string]func(doc *indexDoc) float64{
+	"externalId":   func(doc *indexDoc) float64 { return float64(doc.ExternalId) },
+	"academicYear": func(doc *indexDoc) float64 { return float64(doc.AcademicYear) },
+	"term":         func(doc *indexDoc) float64 { return float64(doc.Term) },
+	"startTime":    func(doc *indexDoc) float64 { return optionalNumber(doc.StartTime) },
+	"endTime":      func(doc *indexDoc) float64 { return optionalNumber(doc.EndTime) },
+}
+
+func optionalNumber(n *int) float64 {
+	if n == nil {
+		return math.NaN()
+	}
+	return float64(*n)
+}
+
+// Values of each field that can be requested as a facet.
+
+// Words that RediSearch ignores by default, in both documents and queries.
+var memStopWords = map[string]bool{
+	"a": true, "is": true, "the": true, "an": true, "and": true, "are": true,
+	"as": true, "at": true, "be": true, "but": true, "by": true, "for": true,
+	"if": true, "in": true, "into": true, "it": true, "no": true, "not": true,
+	"of": true, "on": true, "or": true, "such": true, "that": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true,
+	"to": true, "was": true, "will": true, "with": true,
+}
+
+// Maximum number of words that a prefix or fuzzy term expands to, like the
+// MAXEXPANSIONS setting of RediSearch.
+const memMaxExpansions = 200
+
+// memSearch implements searchBackend with an inverted index in Go, so it does
+// not need Redis. It supports the subset of the RediSearch query language used
+// by the app: terms, prefixes, fuzzy terms, phrases, field modifiers, tag and
+// numeric filters, as well as intersection, union, negation, and optional
+// terms. Phonetic matching of instructor names is not supported.
+type memSearch struct {
+	mu sync.RWMutex // Protects ix, which is swapped out on reload.
+	ix *memIndex
+}
+
+func newMemSearch() *memSearch {
+	return &memSearch{ix: buildMemIndex(nil, nil)}
+}
+
+// An occurrence of a word in a field of a document.
+type posting struct {
+	doc  int32 // Index of the document.
+	freq int32 // Number of times the word appears in the field.
+}
+
+// An immutable inverted index over a set of courses.
+type memIndex struct {
+	docs     []indexDoc
+	postings []map[string][]posting        // Postings of each word, by text field.
+	docFreq  map[string]int                // Number of documents containing each word.
+	words    []string                      // All distinct words, sorted.
+	stems    map[string][]string           // Words that share each stem.
+	tags     map[string]map[string][]int32 // Documents with each lowercased tag, by field.
+	numbers  map[string][]float64          // Value of each numeric field, by document.
+	synonyms map[string][]string           // Other terms in the synonym groups of words.
+	phrases  map[string][]string           // Other terms in the synonym groups of phrases.
+
+	// Words of each text field by document, for checking phrase matches. These
+	// are IDs from phraseIds, with separators of -1 between values.
+	phraseText [][][]int32
+	phraseIds  map[string]int32 // IDs of the words in phraseText.
+}
+
+// Normalize a word for phrase matching, which uses stems in stemmed fields.
+func phraseWord(field int, word string) string {
+	if memTextFields[field].stem {
+		return stem(word)
+	}
+	return word
+}
+
+// Split text into lowercased words, as they are indexed.
+func memWords(text string) []string {
+	return splitWords(strings.ToLower(text))
+}
+
+func buildMemIndex(data []datasource.Course, synonyms [][]string) *memIndex {
+	ix := &memIndex{
+		docs:     make([]indexDoc, len(data)),
+		postings: make([]map[string][]posting, len(memTextFields)),
+		docFreq:  make(map[string]int),
+		stems:    make(map[string][]string),
+		tags:     make(map[string]map[string][]int32),
+		numbers:  make(map[string][]float64),
+		synonyms: make(map[string][]string),
+		phrases:  make(map[string][]string),
+
+		phraseText: make([][][]int32, len(data)),
+		phraseIds:  make(map[string]int32),
+	}
+	for f := range memTextFields {
+		ix.postings[f] = make(map[string][]posting)
+	}
+	for name := range memTagFields {
+		ix.tags[name] = make(map[string][]int32)
+	}
+	for name := range memNumericFields {
+		ix.numbers[name] = make([]float64, len(data))
+	}
+
+	for i, course := range data {
+		doc := int32(i)
+		ix.docs[i] = newIndexDoc(course)
+		seen := make(map[string]bool)
+		ix.phraseText[i] = make([][]int32, len(memTextFields))
+		for f, field := range memTextFields {
+			counts := make(map[string]int32)
+			var text []int32
+			for j, value := range field.values(&ix.docs[i]) {
+				if j > 0 {
+					text = append(text, -1) // Phrases can't span values.
+				}
+				for _, word := range memWords(value) {
+					if memStopWords[word] {
+						continue
+					}
+					counts[word]++
+					normalized := phraseWord(f, word)
+					id, ok := ix.phraseIds[normalized]
+					if !ok {
+						id = int32(len(ix.phraseIds))
+						ix.phraseIds[normalized] = id
+					}
+					text = append(text, id)
+				}
+			}
+			ix.phraseText[i][f] = text
+			for word, count := range counts {
+				ix.postings[f][word] = append(ix.postings[f][word], posting{doc, count})
+				if !seen[word] {
+					seen[word] = true
+					ix.docFreq[word]++
+				}
+			}
+		}
+		for name, values := range memTagFields {
+			for _, value := range values(&ix.docs[i]) {
+				value = strings.ToLower(value)
+				ix.tags[name][value] = append(ix.tags[name][value], doc)
+			}
+		}
+		for name, value := range memNumericFields {
+			ix.numbers[name][i] = value(&ix.docs[i])
+		}
+	}
+
+	ix.words = make([]string, 0, len(ix.docFreq))
+	for word := range ix.docFreq {
+		ix.words = append(ix.words, word)
+		ix.stems[stem(word)] = append(ix.stems[stem(word)], word)
+	}
+	sort.Strings(ix.words)
+
+	for _, group := range synonyms {
+		for _, term := range group {
+			if strings.Contains(term, " ") {
+				ix.phrases[term] = append(ix.phrases[term], otherTerms(group, term)...)
+			} else {
+				ix.synonyms[term] = append(ix.synonyms[term], otherTerms(group, term)...)
+			}
+		}
+	}
+	return ix
+}
+
+func (ms *memSearch) current() *memIndex {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.ix
+}
+
+func (ms *memSearch) index(data []datasource.Course, synonyms [][]string) error {
+	ix := buildMemIndex(data, synonyms)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.ix = ix
+	return nil
+}
+
+func (ms *memSearch) documents() (int64, error) {
+	ix := ms.current()
+	if ix == nil {
+		return 0, nil
+	}
+	return int64(len(ix.docs)), nil
+}
+
+func (ms *memSearch) search(query, sortOrder string, offset, limit int) (int64, []string, error) {
+	ix := ms.current()
+	scores, err := ix.query(query)
+	if err != nil {
+		return 0, nil, err
+	}
+	docs := make([]int32, 0, len(scores))
+	for doc := range scores {
+		docs = append(docs, doc)
+	}
+	less := func(a, b int32) bool {
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	}
+	switch sortOrder {
+	case "newest", "oldest":
+		less = func(a, b int32) bool {
+			ta, tb := ix.docs[a].Term, ix.docs[b].Term
+			if ta != tb {
+				return (ta > tb) == (sortOrder == "newest")
+			}
+			return a < b
+		}
+	case "catalog":
+		less = func(a, b int32) bool {
+			ka, kb := strings.ToLower(ix.docs[a].CatalogSort), strings.ToLower(ix.docs[b].CatalogSort)
+			if ka != kb {
+				return ka < kb
+			}
+			return a < b
+		}
+	case "title":
+		less = func(a, b int32) bool {
+			ka, kb := strings.ToLower(ix.docs[a].Title), strings.ToLower(ix.docs[b].Title)
+			if ka != kb {
+				return ka < kb
+			}
+			return a < b
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return less(docs[i], docs[j]) })
+
+	ids := []string{}
+	for i := offset; i < len(docs) && i < offset+limit; i++ {
+		ids = append(ids, ix.docs[docs[i]].Id)
+	}
+	return int64(len(docs)), ids, nil
+}
+
+func (ms *memSearch) facets(query string, fields []string) (map[string][]facetCount, error) {
+	ix := ms.current()
+	scores, err := ix.query(query)
+	if err != nil {
+		return nil, err
+	}
+	courses := make([]*datasource.Course, 0, len(scores))
+	for doc := range scores {
+		courses = append(courses, &ix.docs[doc].Course)
+	}
+	return countFacets(courses, fields)
+}
+
+// Inverse document frequency of a word, for scoring.
+func (ix *memIndex) idf(word string) float64 {
+	return math.Log(1 + float64(len(ix.docs))/float64(ix.docFreq[word]+1))
+}
+
+// Find the words in the index that start with a prefix.
+func (ix *memIndex) withPrefix(prefix string) []string {
+	i := sort.SearchStrings(ix.words, prefix)
+	var words []string
+	for ; i < len(ix.words) && strings.HasPrefix(ix.words[i], prefix); i++ {
+		if len(words) == memMaxExpansions {
+			break
+		}
+		words = append(words, ix.words[i])
+	}
+	return words
+}
+
+// Find the words in the index within an edit distance of a word.
+func (ix *memIndex) withinDistance(word string, dist int) []string {
+	var words []string
+	for _, candidate := range ix.words {
+		if len(words) == memMaxExpansions {
+			break
+		}
+		if d := len(candidate) - len(word); d > dist || -d > dist {
+			continue
+		}
+		if editDistance(word, candidate, dist+1) <= dist {
+			words = append(words, candidate)
+		}
+	}
+	return words
+}
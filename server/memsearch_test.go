@@ -0,0 +1,251 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"classes.wtf/datasource"
+)
+
+// A small catalog for testing queries, with one course per interesting case.
+func testCourses() []datasource.Course {
+	tuTh := func(start, end string) []datasource.MeetingPattern {
+		return []datasource.MeetingPattern{{
+			StartTime: start, EndTime: end, MeetsOnTuesday: true, MeetsOnThursday: true,
+		}}
+	}
+	return []datasource.Course{
+		{
+			Id: "cs50", Subject: "COMPSCI", CatalogNumber: "50",
+			Title:       "Introduction to Computer Science",
+			Description: "<p>Algorithms and <b>data structures</b>, for beginners.</p>",
+			Level:       "Intro", Semester: "Fall 2022", AcademicYear: 2023, Component: "Lecture",
+			Instructors: []datasource.Instructor{{Name: "David Malan"}},
+			MeetingPatterns: []datasource.MeetingPattern{{
+				StartTime: "10:30", EndTime: "11:45", MeetsOnMonday: true, MeetsOnWednesday: true,
+			}},
+		},
+		{
+			Id: "cs124", Subject: "COMPSCI", CatalogNumber: "124",
+			Title:       "Data Structures and Algorithms",
+			Description: "<p>Design and analysis of efficient algorithms.</p>",
+			Level:       "Undergrad", Semester: "Spring 2023", AcademicYear: 2023, Component: "Lecture",
+			Instructors:     []datasource.Instructor{{Name: "Jelani Nelson"}},
+			MeetingPatterns: tuTh("13:30", "14:45"),
+		},
+		{
+			Id: "stat110", Subject: "STAT", CatalogNumber: "110",
+			Title:       "Introduction to Probability",
+			Description: "<p>Random variables and distributions.</p>",
+			Level:       "Intro", Semester: "Fall 2021", AcademicYear: 2022, Component: "Lecture",
+			Instructors:     []datasource.Instructor{{Name: "Joe Blitzstein"}},
+			MeetingPatterns: tuTh("12:00", "13:15"),
+		},
+		{
+			Id: "math55", Subject: "MATH", CatalogNumber: "55A",
+			Title:       "Honors Abstract Algebra",
+			Description: "<p>Groups, rings and fields.</p>",
+			Level:       "Undergrad", Semester: "Fall 2022", AcademicYear: 2023, Component: "Lecture",
+		},
+		{
+			Id: "expos", Subject: "EXPOS", CatalogNumber: "S-101",
+			Title:       "Writing about Science",
+			Description: "<p>Essays on the history of science.</p>",
+			Level:       "Intro", Semester: "Summer 2022", AcademicYear: 2022, Component: "Seminar",
+			GenEdArea: []string{"Science & Technology in Society"},
+		},
+		{
+			Id: "stat101", Subject: "STAT", CatalogNumber: "101",
+			Title:       "Statistics Seminar",
+			Description: "<p>Reading group for graduate students.</p>",
+			Level:       "Graduate", Semester: "Spring 2021", AcademicYear: 2021, Component: "Seminar",
+		},
+	}
+}
+
+func newTestMemSearch(t *testing.T) *memSearch {
+	ms := newMemSearch()
+	synonyms := [][]string{{"cs", "compsci"}, {"ml", "machine learning"}}
+	if err := ms.index(testCourses(), synonyms); err != nil {
+		t.Fatal(err)
+	}
+	return ms
+}
+
+func TestMemSearchQueries(t *testing.T) {
+	ms := newTestMemSearch(t)
+	all := []string{"cs124", "cs50", "expos", "math55", "stat101", "stat110"}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Terms are intersected, matching stems in any text field.
+		{"algorithms", []string{"cs124", "cs50"}},
+		{"algorithm", []string{"cs124", "cs50"}},
+		{"introduction probability", []string{"stat110"}},
+		{"the algorithms", []string{"cs124", "cs50"}}, // Stop words are ignored.
+		{"*", all},
+
+		// Negation, alone or within an intersection.
+		{"algorithms -introduction", []string{"cs124"}},
+		{"-introduction", []string{"cs124", "expos", "math55", "stat101"}},
+		{"-(introduction | seminar)", []string{"cs124", "expos", "math55"}},
+		{"algorithms -@title:algorithms", []string{"cs50"}},
+
+		// Hyphens inside a word are not negation.
+		{"S-101", []string{"expos"}},
+		{"s-101 writing", []string{"expos"}},
+		{"s -101", []string{}},
+
+		// Field modifiers scope terms to one or more text fields.
+		{"@title:algorithms", []string{"cs124"}},
+		{"@title:(data structures)", []string{"cs124"}},
+		{"@description:data", []string{"cs50"}},
+		{"@title|description:random", []string{"stat110"}},
+		{"@subject:compsci", []string{"cs124", "cs50"}},
+		{"@instructor:malan", []string{"cs50"}},
+		{"@number:101", []string{"expos", "stat101"}},
+
+		// Numeric ranges, with inclusive, exclusive and infinite bounds.
+		{"@academicYear:[2023 2023]", []string{"cs124", "cs50", "math55"}},
+		{"@academicYear:[(2022 +inf]", []string{"cs124", "cs50", "math55"}},
+		{"@academicYear:[-inf (2023]", []string{"expos", "stat101", "stat110"}},
+		{"@academicYear:[2021, 2021]", []string{"stat101"}},
+		{"@startTime:[0 720]", []string{"cs50", "stat110"}}, // Missing times never match.
+		{"@endTime:[(795 inf]", []string{"cs124"}},
+
+		// Tags, matched case-insensitively, with alternatives.
+		{"@level:{Intro}", []string{"cs50", "expos", "stat110"}},
+		{"@level:{undergrad | Graduate}", []string{"cs124", "math55", "stat101"}},
+		{"@days:{Tu}", []string{"cs124", "stat110"}},
+		{"@genEdArea:{Science & Technology in Society}", []string{"expos"}},
+		{"science @level:{Intro}", []string{"cs50", "expos"}},
+
+		// Prefixes and phrases.
+		{"comp*", []string{"cs124", "cs50"}},
+		{"prob*", []string{"stat110"}},
+		{"@title:intro*", []string{"cs50", "stat110"}},
+		{`"data structures"`, []string{"cs124", "cs50"}},
+		{`"structures data"`, []string{}},
+		{`@title:"computer science"`, []string{"cs50"}},
+
+		// Unions, optional terms and fuzzy terms.
+		{"algebra | probability", []string{"math55", "stat110"}},
+		{"(algebra | probability) honors", []string{"math55"}},
+		{"~algorithms introduction", []string{"cs50", "stat110"}},
+		{"%algoritms%", []string{"cs124", "cs50"}},
+		{"%%algortms%%", []string{"cs124", "cs50"}},
+
+		// Synonym groups.
+		{"cs", []string{"cs124", "cs50"}},
+
+		// Query attributes are accepted and ignored.
+		{"algebra => { $weight: 2.0 }", []string{"math55"}},
+	}
+	for _, tt := range tests {
+		count, ids, err := ms.search(tt.query, "relevance", 0, 100)
+		if err != nil {
+			t.Errorf("search(%q) failed: %v", tt.query, err)
+			continue
+		}
+		sort.Strings(ids)
+		if count != int64(len(tt.want)) || !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("search(%q) = %d %v, want %v", tt.query, count, ids, tt.want)
+		}
+	}
+}
+
+func TestMemSearchErrors(t *testing.T) {
+	ms := newTestMemSearch(t)
+	for _, query := range []string{
+		"(algorithms",
+		"algorithms)",
+		"@nope:algorithms",
+		"@title:[1 2]",
+		"@level:[1 2]",
+		"@academicYear:{2023}",
+		"@academicYear:[2023]",
+		"@academicYear:[a b]",
+		"{Intro}",
+		"%algorithms",
+		"%%%%algorithms%%%%",
+	} {
+		if _, _, err := ms.search(query, "relevance", 0, 10); err == nil {
+			t.Errorf("search(%q) succeeded, want a syntax error", query)
+		}
+	}
+}
+
+func TestMemSearchOrder(t *testing.T) {
+	ms := newTestMemSearch(t)
+	tests := []struct {
+		query, sort   string
+		offset, limit int
+		want          []string
+	}{
+		// A title match outweighs a description match.
+		{"algorithms", "relevance", 0, 10, []string{"cs124", "cs50"}},
+		{"algorithms", "title", 0, 10, []string{"cs124", "cs50"}},
+		{"@level:{Intro}", "newest", 0, 10, []string{"cs50", "expos", "stat110"}},
+		{"@level:{Intro}", "oldest", 0, 10, []string{"stat110", "expos", "cs50"}},
+		// Catalog numbers are compared numerically within a subject.
+		{"*", "catalog", 0, 10, []string{"cs50", "cs124", "expos", "math55", "stat101", "stat110"}},
+		{"*", "catalog", 2, 2, []string{"expos", "math55"}},
+		{"*", "catalog", 5, 10, []string{"stat110"}},
+		{"*", "catalog", 10, 10, []string{}},
+	}
+	for _, tt := range tests {
+		_, ids, err := ms.search(tt.query, tt.sort, tt.offset, tt.limit)
+		if err != nil {
+			t.Errorf("search(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("search(%q, %q, %d, %d) = %v, want %v",
+				tt.query, tt.sort, tt.offset, tt.limit, ids, tt.want)
+		}
+	}
+}
+
+func TestMemSearchDescriptionHTML(t *testing.T) {
+	ms := newMemSearch()
+	data := []datasource.Course{{
+		Id:          "graphs",
+		Title:       "Discrete Math",
+		Description: "<ul><li>Graphs</li><li>Trees</li></ul><p>Algorithms</p>",
+	}}
+	if err := ms.index(data, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"graphs", "trees", "algorithms", `"trees algorithms"`} {
+		if count, _, err := ms.search(query, "relevance", 0, 10); err != nil || count != 1 {
+			t.Errorf("search(%q) = %d, %v; want 1 result", query, count, err)
+		}
+	}
+	v := newVocabulary(data)
+	if v.freqs["algorithms"] != 1 || v.freqs["graphstreesalgorithms"] != 0 {
+		t.Errorf("vocabulary has words joined across tags: %v", v.freqs)
+	}
+}
+
+func TestMemSearchPhraseValues(t *testing.T) {
+	ms := newMemSearch()
+	data := []datasource.Course{{
+		Id:          "team",
+		Instructors: []datasource.Instructor{{Name: "Ann Smith"}, {Name: "John Doe"}},
+	}}
+	if err := ms.index(data, nil); err != nil {
+		t.Fatal(err)
+	}
+	for query, want := range map[string]int64{
+		`"ann smith"`:              1,
+		`@instructor:"john doe"`:   1,
+		`"smith john"`:             0, // Phrases don't span separate instructors.
+		`@instructor:"ann  smith"`: 1,
+	} {
+		if count, _, err := ms.search(query, "relevance", 0, 10); err != nil || count != want {
+			t.Errorf("search(%q) = %d, %v; want %d", query, count, err, want)
+		}
+	}
+}
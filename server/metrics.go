@@ -0,0 +1,160 @@
+// Prometheus metrics, written in the plain text exposition format.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the query latency histogram buckets, in seconds. These are
+// concentrated around the 30 millisecond latency goal.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.02, 0.03, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Counters and gauges describing the server, exported at "/metrics".
+type metrics struct {
+	queries     uint64 // Number of search queries, including failed ones.
+	zeroResults uint64 // Number of successful queries with no results.
+	indexedDocs int64  // Number of courses in the active index.
+	buildNanos  int64  // Duration of the most recent index build.
+
+	mu       sync.Mutex        // Protects the fields below.
+	errors   map[string]uint64 // Number of failed requests, by error type.
+	buckets  []uint64          // Cumulative latency counts, per bucket.
+	latCount uint64
+	latSum   float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		errors:  make(map[string]uint64),
+		buckets: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// Record a search query that took the given time to execute.
+func (m *metrics) observeQuery(elapsed time.Duration, count int64, err error) {
+	atomic.AddUint64(&m.queries, 1)
+	if err == nil && count == 0 {
+		atomic.AddUint64(&m.zeroResults, 1)
+	}
+	secs := elapsed.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			m.buckets[i]++
+		}
+	}
+	m.latCount++
+	m.latSum += secs
+}
+
+// Record a failed request, with a short error type like "invalid_param".
+func (m *metrics) observeError(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[kind]++
+}
+
+// Record a newly built index and how long it took to build.
+func (m *metrics) observeBuild(docs int, elapsed time.Duration) {
+	atomic.StoreInt64(&m.indexedDocs, int64(docs))
+	atomic.StoreInt64(&m.buildNanos, int64(elapsed))
+}
+
+// Implemented by search backends that can report their memory usage.
+type memoryReporter interface {
+	memoryUsage() (int64, error)
+}
+
+// Serves metrics in the Prometheus text format, at "/metrics".
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := s.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric(w, "classes_search_queries_total", "counter",
+		"Number of search queries served.", float64(atomic.LoadUint64(&m.queries)))
+	writeMetric(w, "classes_search_zero_result_queries_total", "counter",
+		"Number of search queries that returned no results.",
+		float64(atomic.LoadUint64(&m.zeroResults)))
+
+	m.mu.Lock()
+	fmt.Fprintf(w, "# HELP classes_search_latency_seconds Time taken to execute search queries.\n")
+	fmt.Fprintf(w, "# TYPE classes_search_latency_seconds histogram\n")
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "classes_search_latency_seconds_bucket{le=%q} %d\n", formatFloat(le), m.buckets[i])
+	}
+	fmt.Fprintf(w, "classes_search_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latCount)
+	fmt.Fprintf(w, "classes_search_latency_seconds_sum %s\n", formatFloat(m.latSum))
+	fmt.Fprintf(w, "classes_search_latency_seconds_count %d\n", m.latCount)
+
+	kinds := make([]string, 0, len(m.errors))
+	for kind := range m.errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(w, "# HELP classes_errors_total Number of failed requests, by error type.\n")
+	fmt.Fprintf(w, "# TYPE classes_errors_total counter\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "classes_errors_total{type=%q} %d\n", kind, m.errors[kind])
+	}
+	m.mu.Unlock()
+
+	writeMetric(w, "classes_indexed_documents", "gauge",
+		"Number of courses in the active search index.",
+		float64(atomic.LoadInt64(&m.indexedDocs)))
+	writeMetric(w, "classes_index_build_seconds", "gauge",
+		"Time taken to build the most recent search index.",
+		time.Duration(atomic.LoadInt64(&m.buildNanos)).Seconds())
+
+	if s.cache != nil {
+		writeMetric(w, "classes_cache_hits_total", "counter",
+			"Number of queries answered by the query cache.",
+			float64(atomic.LoadUint64(&s.cache.hits)))
+		writeMetric(w, "classes_cache_misses_total", "counter",
+			"Number of queries that missed the query cache.",
+			float64(atomic.LoadUint64(&s.cache.misses)))
+	}
+
+	if reporter, ok := s.backend.(memoryReporter); ok {
+		if used, err := reporter.memoryUsage(); err != nil {
+			slog.Warn("Failed to read memory usage for metrics", "err", err)
+		} else {
+			writeMetric(w, "classes_redis_memory_bytes", "gauge",
+				"Memory used by Redis, as reported by INFO.", float64(used))
+		}
+	}
+}
+
+// Write a single metric without labels, along with its help and type lines.
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Return the number of bytes of memory used by Redis, from "INFO memory".
+func (ts *TextSearch) memoryUsage() (int64, error) {
+	info, err := ts.rdb.Info(ts.ctx, "memory").Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "used_memory:") {
+			return strconv.ParseInt(strings.TrimPrefix(line, "used_memory:"), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("used_memory missing from redis info")
+}
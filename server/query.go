@@ -0,0 +1,183 @@
+// Lightweight lexing of the RediSearch query language, for features that need
+// to inspect the terms of a query outside of Redis.
+
+package server
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // A bare term, like "algorithms" or "comp*".
+	tokenPhrase                  // A quoted exact phrase, like "machine learning".
+	tokenField                   // A field modifier, like "@title:".
+	tokenTag                     // A braced tag list, like "{Intro | Undergrad}".
+	tokenRange                   // A bracketed numeric range, like "[2020 2023]".
+	tokenPunct                   // A single operator or grouping character.
+)
+
+// A token in a search query, along with its position in the original string.
+type token struct {
+	kind       tokenKind
+	text       string // Text of the token, without any trailing "*" or quotes.
+	prefix     bool   // Set if this is a prefix term, ending in "*".
+	start, end int    // Byte offsets of the token in the query.
+}
+
+// Characters that separate terms in RediSearch's default tokenizer.
+const separators = ",.<>{}[]\"':;!@#$%^&*()-+=~|/\\"
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune(separators, r)
+}
+
+// Split a query into tokens. This is forgiving of syntax errors, since it is
+// only used to extract terms for best-effort features like highlighting.
+func lexQuery(query string) []token {
+	var tokens []token
+	i := 0
+	for i < len(query) {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+
+		case r == '\\' && i+size < len(query):
+			// Escaped characters are part of a word, like "c\+\+".
+			i = scanWord(query, i)
+			tokens = append(tokens, wordToken(query, start, i))
+
+		case r == '"' || r == '{' || r == '[':
+			kind, close := tokenPhrase, byte('"')
+			if r == '{' {
+				kind, close = tokenTag, '}'
+			} else if r == '[' {
+				kind, close = tokenRange, ']'
+			}
+			text := query[i+1:]
+			if n := strings.IndexByte(text, close); n != -1 {
+				text = text[:n]
+				i += n + 2
+			} else {
+				i = len(query) // Unterminated, so consume the rest of the query.
+			}
+			tokens = append(tokens, token{kind: kind, text: text, start: start, end: i})
+
+		case r == '@':
+			end := i + 1
+			for end < len(query) && (isWordRune(rune(query[end])) || query[end] == '|') {
+				end++
+			}
+			if end < len(query) && query[end] == ':' {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenField, text: query[i+1 : end], start: start, end: end})
+			i = end
+
+		case isWordRune(r):
+			i = scanWord(query, i)
+			tokens = append(tokens, wordToken(query, start, i))
+
+		default:
+			i += size
+			tokens = append(tokens, token{kind: tokenPunct, text: query[start:i], start: start, end: i})
+		}
+	}
+	return tokens
+}
+
+// Find the end of a word starting at position i, including a trailing "*".
+// A hyphen only negates at the start of a term, so a hyphen between word
+// characters, as in "S-101", is part of the word.
+func scanWord(query string, i int) int {
+	start := i
+	for i < len(query) {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		if r == '\\' && i+size < len(query) {
+			_, next := utf8.DecodeRuneInString(query[i+size:])
+			i += size + next
+		} else if isWordRune(r) {
+			i += size
+		} else if r == '-' && i > start && i+1 < len(query) {
+			if next, _ := utf8.DecodeRuneInString(query[i+1:]); !isWordRune(next) {
+				break
+			}
+			i += size
+		} else {
+			break
+		}
+	}
+	if i < len(query) && query[i] == '*' {
+		i++
+	}
+	return i
+}
+
+func wordToken(query string, start, end int) token {
+	text := query[start:end]
+	prefix := strings.HasSuffix(text, "*")
+	text = strings.ReplaceAll(strings.TrimSuffix(text, "*"), "\\", "")
+	return token{kind: tokenWord, text: text, prefix: prefix, start: start, end: end}
+}
+
+// A term that a query is searching for.
+type queryTerm struct {
+	word   string // Lowercased text of the term.
+	prefix bool   // Set if the term matches any word with this prefix.
+}
+
+// Extract the positive search terms from a query, skipping negated terms and
+// groups, as well as tag and numeric filters.
+func queryTerms(query string) []queryTerm {
+	var terms []queryTerm
+	negated := false // Set if the next term or group is negated.
+	skipDepth := 0   // Nesting depth of the current negated group, if any.
+	for _, tok := range lexQuery(query) {
+		if skipDepth > 0 {
+			if tok.kind == tokenPunct && tok.text == "(" {
+				skipDepth++
+			} else if tok.kind == tokenPunct && tok.text == ")" {
+				skipDepth--
+			}
+			continue
+		}
+		switch tok.kind {
+		case tokenWord:
+			if !negated {
+				// Hyphenated words like "S-101" are indexed as separate words.
+				words := splitWords(tok.text)
+				for i, word := range words {
+					terms = append(terms, queryTerm{strings.ToLower(word), tok.prefix && i == len(words)-1})
+				}
+			}
+		case tokenPhrase:
+			if !negated {
+				for _, word := range splitWords(tok.text) {
+					terms = append(terms, queryTerm{strings.ToLower(word), false})
+				}
+			}
+		case tokenField:
+			continue // Field modifiers apply to the next term.
+		case tokenPunct:
+			if tok.text == "-" {
+				negated = true
+				continue
+			}
+			if tok.text == "(" && negated {
+				skipDepth = 1
+			}
+		}
+		negated = false
+	}
+	return terms
+}
+
+// Split text into words using the same separators as the query tokenizer.
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) })
+}
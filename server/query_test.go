@@ -0,0 +1,53 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexQuery(t *testing.T) {
+	type tok struct {
+		kind tokenKind
+		text string
+	}
+	tests := []struct {
+		query string
+		want  []tok
+	}{
+		{"S-101", []tok{{tokenWord, "S-101"}}},
+		{"-foo", []tok{{tokenPunct, "-"}, {tokenWord, "foo"}}},
+		{"foo -bar", []tok{{tokenWord, "foo"}, {tokenPunct, "-"}, {tokenWord, "bar"}}},
+		{"foo- bar", []tok{{tokenWord, "foo"}, {tokenPunct, "-"}, {tokenWord, "bar"}}},
+		{"(-foo)", []tok{{tokenPunct, "("}, {tokenPunct, "-"}, {tokenWord, "foo"}, {tokenPunct, ")"}}},
+		{`c\+\+ comp*`, []tok{{tokenWord, "c++"}, {tokenWord, "comp"}}},
+		{`@title:"data structures"`, []tok{{tokenField, "title:"}, {tokenPhrase, "data structures"}}},
+		{"@level:{Intro | Undergrad}", []tok{{tokenField, "level:"}, {tokenTag, "Intro | Undergrad"}}},
+		{"@term:[(2020 +inf]", []tok{{tokenField, "term:"}, {tokenRange, "(2020 +inf"}}},
+	}
+	for _, tt := range tests {
+		var got []tok
+		for _, t := range lexQuery(tt.query) {
+			got = append(got, tok{t.kind, t.text})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lexQuery(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []queryTerm
+	}{
+		{"data -structures", []queryTerm{{"data", false}}},
+		{"S-101 comp*", []queryTerm{{"s", false}, {"101", false}, {"comp", true}}},
+		{`-(foo bar) "machine learning"`, []queryTerm{{"machine", false}, {"learning", false}}},
+		{"@level:{Intro} algorithms", []queryTerm{{"algorithms", false}}},
+	}
+	for _, tt := range tests {
+		if got := queryTerms(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("queryTerms(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Per-client rate limiting of API requests.
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often to forget clients whose token buckets have refilled.
+const rateLimitSweepInterval = time.Minute
+
+// A token bucket rate limiter, keyed by client IP address. Each client can
+// make `burst` requests at once, which refill at `rate` requests per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // When tokens was last updated.
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take a token for a request from the client. If there are none left, this
+// returns false along with how long to wait until the next token.
+func (rl *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget clients whose buckets would be full by now, so that the map of
+// buckets does not grow without bound.
+func (rl *rateLimiter) sweep(now time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+// Sweep the rate limiter periodically until the server stops.
+func (s *Server) sweepRateLimiter() {
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.limiter.sweep(now)
+		}
+	}
+}
+
+// Wrap a handler to reject requests from clients over their rate limit, with
+// a 429 response. This does nothing if rate limiting is disabled.
+func (s *Server) rateLimited(h http.Handler) http.Handler {
+	if s.limiter == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := s.limiter.allow(s.clientIP(r), time.Now()); !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			s.metrics.observeError("rate_limited")
+			writeError(w, http.StatusTooManyRequests,
+				fmt.Errorf("rate limit exceeded, try again in %ds", secs))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Return the IP address of the client making a request. Behind a trusted
+// proxy, this comes from the headers that the proxy adds.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("Fly-Client-IP")); ip != "" {
+			return ip
+		}
+		// The proxy appends the address it received the request from, so the
+		// last entry is the only one that clients cannot forge.
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			addrs := strings.Split(xff, ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Check that a query parameter is not too long, or otherwise write an error
+// response.
+func (s *Server) checkLength(w http.ResponseWriter, name, value string) bool {
+	if s.maxQueryLength > 0 && len(value) > s.maxQueryLength {
+		s.invalidParam(w, fmt.Errorf("invalid %s: longer than %d bytes", name, s.maxQueryLength))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+// Lifecycle of the Redis server that runs as a subprocess.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/antelman107/net-wait-go/wait"
+)
+
+// Options for launching Redis as a subprocess.
+type redisOptions struct {
+	port    int      // Port for Redis to listen on.
+	local   bool     // Run Redis in a Docker container, for local development.
+	image   string   // Docker image to run in local mode.
+	modules []string // Paths of modules to load, when not in local mode.
+}
+
+// Name of the Docker container used to run Redis in local mode. This includes
+// the port, so that several instances can run side by side.
+func (o redisOptions) container() string {
+	return fmt.Sprintf("classes.wtf-redis-%d", o.port)
+}
+
+func (o redisOptions) addr() string {
+	return fmt.Sprintf("localhost:%d", o.port)
+}
+
+// How long to wait for Redis to exit before killing it.
+const redisStopTimeout = 2 * time.Second
+
+// Maximum delay between attempts to restart Redis after it fails to start.
+const maxRestartDelay = 30 * time.Second
+
+// A Redis server running as a subprocess of the application.
+type redisProcess struct {
+	cmd  *exec.Cmd
+	opts redisOptions
+	done chan struct{} // Closed when the subprocess exits.
+	err  error         // Exit status of the subprocess, once done.
+}
+
+// Start Redis in the background and wait for it to accept connections.
+func startRedis(opts redisOptions) (*redisProcess, error) {
+	var cmd *exec.Cmd
+	if opts.local {
+		exec.Command("docker", "kill", opts.container()).Run()
+		cmd = exec.Command("docker", "run", "--name", opts.container(),
+			"-i", "--rm", "-p", fmt.Sprintf("%d:6379", opts.port), opts.image,
+			"redis-stack-server", "--save", "")
+	} else {
+		var args []string
+		for _, module := range opts.modules {
+			args = append(args, "--loadmodule", module)
+		}
+		args = append(args, "--port", fmt.Sprint(opts.port), "--save", "")
+		cmd = exec.Command("redis-server", args...)
+	}
+	stdout, stderr := logOutput("stdout"), logOutput("stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+
+	p := &redisProcess{cmd: cmd, opts: opts, done: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+		close(p.done)
+	}()
+
+	if !wait.New().Do([]string{opts.addr()}) {
+		p.stop()
+		return nil, fmt.Errorf("failed to connect to redis")
+	}
+	return p, nil
+}
+
+// Return a writer for an output stream of Redis, which logs each line written
+// to it. This keeps the output parseable when logging in JSON.
+func logOutput(stream string) io.WriteCloser {
+	r, w := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			slog.Info(scanner.Text(), "source", "redis", "stream", stream)
+		}
+		if err := scanner.Err(); err != nil {
+			slog.Warn("Failed to read Redis output", "stream", stream, "err", err)
+		}
+		io.Copy(io.Discard, r) // Don't block Redis if a line was too long.
+	}()
+	return w
+}
+
+// Stop the Redis subprocess, giving it a short time to exit cleanly before
+// killing it. In local mode, this also stops the Docker container.
+func (p *redisProcess) stop() {
+	select {
+	case <-p.done:
+		return // Already exited, for example from a terminal interrupt.
+	default:
+	}
+
+	slog.Info("Stopping Redis server")
+	if p.opts.local {
+		// The container may outlive the docker client, so stop it directly.
+		exec.Command("docker", "stop", "-t", "1", p.opts.container()).Run()
+	}
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(redisStopTimeout):
+		slog.Warn("Redis did not exit in time, killing it", "timeout", redisStopTimeout)
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// Keeps a Redis subprocess running, restarting it whenever it exits.
+type redisSupervisor struct {
+	opts redisOptions
+
+	mu      sync.Mutex // Protects the fields below.
+	proc    *redisProcess
+	stopped bool
+}
+
+func startSupervisor(opts redisOptions) (*redisSupervisor, error) {
+	proc, err := startRedis(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &redisSupervisor{opts: opts, proc: proc}, nil
+}
+
+// Watch the subprocess until the supervisor is stopped. When Redis exits, the
+// exited callback is called with its status, and once a new Redis is running
+// the restarted callback is called so that it can be repopulated. The channel
+// passed to restarted is closed if the new Redis exits too.
+func (s *redisSupervisor) watch(exited func(error), restarted func(lost <-chan struct{})) {
+	for {
+		s.mu.Lock()
+		proc := s.proc
+		s.mu.Unlock()
+
+		<-proc.done
+		if s.isStopped() {
+			return
+		}
+		exited(proc.err)
+
+		for delay := time.Second; ; delay *= 2 {
+			if s.isStopped() {
+				return
+			}
+			proc, err := startRedis(s.opts)
+			if err == nil {
+				s.mu.Lock()
+				if s.stopped {
+					s.mu.Unlock()
+					proc.stop()
+					return
+				}
+				s.proc = proc
+				s.mu.Unlock()
+				break
+			}
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+			slog.Error("Failed to restart Redis", "err", err, "retry", delay)
+			time.Sleep(delay)
+		}
+		s.mu.Lock()
+		lost := s.proc.done
+		s.mu.Unlock()
+		restarted(lost)
+	}
+}
+
+func (s *redisSupervisor) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// Stop the current Redis subprocess, and don't restart it again.
+func (s *redisSupervisor) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	proc := s.proc
+	s.mu.Unlock()
+	proc.stop()
+}
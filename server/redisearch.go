@@ -0,0 +1,287 @@
+// Search backend using the RediSearch and RedisJSON modules.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"classes.wtf/datasource"
+)
+
+// How long an old index is kept around after being replaced on reload.
+const dropGracePeriod = 30 * time.Second
+
+// How old an inactive index must be before another process drops it as stale.
+// This is longer than indexing takes, so that indexes still being built by
+// other servers sharing the namespace are left alone.
+const staleIndexAge = time.Hour
+
+// How often a server marks its active index as live. The mark expires after
+// a few missed refreshes, so indexes of servers that died are dropped as stale.
+const liveInterval = time.Minute
+
+// Returned by queries while there is no active index, such as after Redis
+// restarts and before the index is rebuilt.
+var errNoIndex = errors.New("no active index")
+
+// TextSearch implements searchBackend with a RediSearch index in Redis.
+type TextSearch struct {
+	ctx       context.Context
+	rdb       *redis.Client
+	namespace string // Prefix of every index name and key in Redis.
+
+	mu  sync.RWMutex // Protects idx, which is swapped out on reload.
+	idx *redisIndex
+}
+
+// An index in RediSearch, which is replaced as a whole on reload.
+type redisIndex struct {
+	name   string // Name of the index in RediSearch.
+	prefix string // Key prefix of the indexed documents.
+	// Synonyms of words and phrases that RediSearch can't match by itself,
+	// which are added to queries by expandSynonyms.
+	expansions map[string][]string
+}
+
+// Return the active index.
+func (ts *TextSearch) current() *redisIndex {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.idx
+}
+
+func (ts *TextSearch) index(data []datasource.Course, synonyms [][]string) error {
+	idx, err := ts.build(data, synonyms)
+	if err != nil {
+		return err
+	}
+	if err := ts.markLive(idx); err != nil {
+		ts.drop(idx.name)
+		return fmt.Errorf("failed to mark index as live: %v", err)
+	}
+	ts.mu.Lock()
+	old := ts.idx
+	ts.idx = idx
+	ts.mu.Unlock()
+	go ts.keepLive(idx)
+	if old != nil {
+		// Keep the old index around for a bit, so in-flight queries can finish.
+		time.AfterFunc(dropGracePeriod, func() { ts.drop(old.name) })
+	}
+	slog.Info("Activated index", "index", idx.name)
+	return nil
+}
+
+// Forget about the active index, after it was lost in a Redis restart.
+func (ts *TextSearch) forget() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.idx = nil
+}
+
+// Create and populate a new index in Redis, without activating it. Each index
+// has its own name and key prefix, so it can be built while another is live.
+// Names start with the creation time and end with a random suffix, like
+// "courses-1700000000-9f86d081", so that servers sharing a namespace never
+// collide, and stale indexes can be recognized by their age.
+func (ts *TextSearch) build(data []datasource.Course, synonyms [][]string) (*redisIndex, error) {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	id := fmt.Sprintf("%d-%x", time.Now().Unix(), suffix)
+	idx := &redisIndex{
+		name:   ts.namespace + "courses-" + id,
+		prefix: ts.namespace + "course:" + id + ":",
+	}
+	err := ts.rdb.Do(ts.ctx,
+		"FT.CREATE", idx.name, "ON", "JSON", "PREFIX", "1", idx.prefix, "NOOFFSETS",
+		"SCHEMA",
+		"$.title", "AS", "title", "TEXT", "WEIGHT", "2", "SORTABLE",
+		"$.description", "AS", "description", "TEXT",
+		"$.subject", "AS", "subject", "TEXT", "NOSTEM", "WEIGHT", "2",
+		"$.catalogNumber", "AS", "number", "TEXT", "NOSTEM", "WEIGHT", "2",
+		"$.externalId", "AS", "externalId", "NUMERIC",
+		"$.semester", "AS", "semester", "TEXT",
+		"$.instructors..name", "AS", "instructor", "TEXT", "NOSTEM", "PHONETIC", "dm:en",
+		"$.component", "AS", "component", "TAG",
+		"$.level", "AS", "level", "TAG",
+		"$.academicYear", "AS", "academicYear", "NUMERIC", "SORTABLE",
+		"$.genEdArea", "AS", "genEdArea", "TAG",
+		"$.catalogSort", "AS", "catalogSort", "TAG", "SORTABLE",
+		"$.term", "AS", "term", "NUMERIC", "SORTABLE",
+		"$.startTime", "AS", "startTime", "NUMERIC",
+		"$.endTime", "AS", "endTime", "NUMERIC",
+		"$.days", "AS", "days", "TAG",
+	).Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index: %v", err)
+	}
+	if err := ts.initSynonyms(idx, synonyms); err != nil {
+		ts.drop(idx.name)
+		return nil, err
+	}
+
+	pipe := ts.rdb.Pipeline()
+	for i, course := range data {
+		id := course.Id
+		s, err := json.Marshal(newIndexDoc(course))
+		if err != nil {
+			ts.drop(idx.name)
+			return nil, fmt.Errorf("failed to marshal course id %v: %v", id, err)
+		}
+		pipe.Do(ts.ctx, "JSON.SET", idx.prefix+id, "$", s)
+		if i%4000 == 3999 || i == len(data)-1 {
+			if _, err := pipe.Exec(ts.ctx); err != nil {
+				ts.drop(idx.name)
+				return nil, fmt.Errorf("error while adding data: %v", err)
+			}
+			pipe = ts.rdb.Pipeline()
+		}
+	}
+	return idx, nil
+}
+
+// Key that exists while some server is querying the index with this name.
+func liveKey(name string) string {
+	return name + ":live"
+}
+
+// Mark an index as live, so that other servers sharing the namespace don't
+// drop it as stale.
+func (ts *TextSearch) markLive(idx *redisIndex) error {
+	return ts.rdb.Set(ts.ctx, liveKey(idx.name), 1, 3*liveInterval).Err()
+}
+
+// Keep marking an index as live until it is replaced or forgotten.
+func (ts *TextSearch) keepLive(idx *redisIndex) {
+	ticker := time.NewTicker(liveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ts.ctx.Done():
+			return
+		}
+		if ts.current() != idx {
+			return
+		}
+		if err := ts.markLive(idx); err != nil {
+			slog.Warn("Failed to mark index as live", "index", idx.name, "err", err)
+		}
+	}
+}
+
+// Drop indexes in the namespace left over from previous runs, which can happen
+// when indexing into an external Redis server. Only indexes that no server has
+// marked as live and that are older than staleIndexAge are dropped, since other
+// servers may be using the same namespace.
+func (ts *TextSearch) dropStale() error {
+	names, err := ts.rdb.Do(ts.ctx, "FT._LIST").StringSlice()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, ts.namespace+"courses-") {
+			continue
+		}
+		created, ok := indexCreated(strings.TrimPrefix(name, ts.namespace+"courses-"))
+		if !ok || time.Since(created) <= staleIndexAge {
+			continue
+		}
+		live, err := ts.rdb.Exists(ts.ctx, liveKey(name)).Result()
+		if err != nil {
+			return err
+		}
+		if live == 0 {
+			slog.Info("Dropping stale index", "index", name, "created", created)
+			ts.drop(name)
+		}
+	}
+	return nil
+}
+
+// Parse the creation time from the suffix of an index name, like
+// "1700000000-9f86d081". Returns false for names not made by build.
+func indexCreated(id string) (time.Time, bool) {
+	secs, rest, ok := strings.Cut(id, "-")
+	if !ok || len(rest) != 8 {
+		return time.Time{}, false
+	}
+	if _, err := hex.DecodeString(rest); err != nil {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(n, 0), true
+}
+
+// Delete an index and all of its documents from Redis.
+func (ts *TextSearch) drop(name string) {
+	if err := ts.rdb.Do(ts.ctx, "FT.DROPINDEX", name, "DD").Err(); err != nil {
+		slog.Warn("Failed to drop index", "index", name, "err", err)
+	}
+	ts.rdb.Del(ts.ctx, liveKey(name))
+}
+
+// Sort orders for search results, mapped to SORTBY arguments.
+var redisSortBy = map[string][]any{
+	"relevance": nil,
+	"newest":    {"SORTBY", "term", "DESC"},
+	"oldest":    {"SORTBY", "term", "ASC"},
+	"catalog":   {"SORTBY", "catalogSort", "ASC"},
+	"title":     {"SORTBY", "title", "ASC"},
+}
+
+// Return the number of documents in the active index, from FT.INFO.
+func (ts *TextSearch) documents() (int64, error) {
+	idx := ts.current()
+	if idx == nil {
+		return 0, ts.rdb.Ping(ts.ctx).Err()
+	}
+	val, err := ts.rdb.Do(ts.ctx, "FT.INFO", idx.name).Slice()
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i+1 < len(val); i += 2 {
+		if redisString(val[i]) == "num_docs" {
+			return strconv.ParseInt(redisString(val[i+1]), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("num_docs missing from index info")
+}
+
+// Execute a full text query on the Redis server, using the query language.
+func (ts *TextSearch) search(query, sort string, offset, limit int) (count int64, results []string, err error) {
+	idx := ts.current()
+	if idx == nil {
+		return 0, nil, errNoIndex
+	}
+	args := []any{"FT.SEARCH", idx.name, idx.expandSynonyms(query), "RETURN", "0"}
+	args = append(args, redisSortBy[sort]...)
+	args = append(args, "LIMIT", offset, limit)
+	val, err := ts.rdb.Do(ts.ctx, args...).Slice()
+	if err != nil {
+		return
+	}
+	count = val[0].(int64)
+	for _, key := range val[1:] {
+		// Keys have a prefix that depends on the index, like
+		// "course:1700000000-9f86d081:<id>".
+		key := key.(string)
+		results = append(results, key[strings.LastIndexByte(key, ':')+1:])
+	}
+	return
+}
@@ -0,0 +1,134 @@
+// Loading and hot reloading of course data into the search index.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"classes.wtf/datasource"
+)
+
+// Read course data and synonyms, then index them in the backend and replace
+// the catalog. Queries continue to be served from the previous data until
+// this finishes.
+//
+// This returns the number of courses that were indexed.
+func (s *Server) load(uri, synonymsFile string) (int, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	var synonyms [][]string
+	if synonymsFile != "" {
+		var err error
+		if synonyms, err = readSynonyms(synonymsFile); err != nil {
+			return 0, fmt.Errorf("could not read synonyms: %v", err)
+		}
+		slog.Info("Loaded synonym groups", "count", len(synonyms))
+	}
+
+	slog.Info("Reading course data", "uri", uri)
+	data, version, err := readData(uri)
+	if err != nil {
+		return 0, fmt.Errorf("could not fetch data: %v", err)
+	}
+	slog.Info("Found courses", "count", len(data), "version", version)
+
+	slog.Info("Indexing course data")
+	start := time.Now()
+	cat, err := newCatalog(data, version, synonyms)
+	if err != nil {
+		return 0, fmt.Errorf("failed to index data: %v", err)
+	}
+	if err := s.backend.index(data, synonyms); err != nil {
+		return 0, fmt.Errorf("failed to index data: %v", err)
+	}
+	s.mu.Lock()
+	s.cat = cat
+	s.mu.Unlock()
+	if s.cache != nil {
+		s.cache.clear()
+	}
+	elapsed := time.Since(start)
+	s.metrics.observeBuild(len(data), elapsed)
+	slog.Info("Finished indexing data", "elapsed", elapsed)
+	return len(data), nil
+}
+
+// Index the courses already in memory again, after the backend has lost them.
+func (s *Server) restore() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cat := s.current()
+	if cat == nil {
+		return nil // Nothing was indexed yet, so there is nothing to restore.
+	}
+	slog.Info("Rebuilding index")
+	start := time.Now()
+	data := make([]datasource.Course, 0, len(cat.vals))
+	for _, course := range cat.vals {
+		data = append(data, course)
+	}
+	if err := s.backend.index(data, cat.synonyms); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.cache.clear()
+	}
+	elapsed := time.Since(start)
+	s.metrics.observeBuild(len(data), elapsed)
+	slog.Info("Finished rebuilding index", "elapsed", elapsed)
+	return nil
+}
+
+// Reload course data on a fixed interval, logging any errors.
+func (s *Server) reloadPeriodically(uri, synonymsFile string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.load(uri, synonymsFile); err != nil {
+				slog.Error("Periodic reload failed", "err", err)
+				s.metrics.observeError("reload")
+			}
+		}
+	}
+}
+
+// Returns a handler that reloads course data on POST requests carrying the
+// admin token, as in "Authorization: Bearer <token>".
+func (s *Server) reloadHandler(uri, synonymsFile, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+			return
+		}
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+			return
+		}
+		start := time.Now()
+		count, err := s.load(uri, synonymsFile)
+		if err != nil {
+			slog.Error("Reload failed", "err", err)
+			s.metrics.observeError("reload")
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"count": count,
+			"time":  time.Since(start).Seconds(),
+		})
+	})
+}
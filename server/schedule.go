@@ -0,0 +1,145 @@
+// Endpoints for planning a schedule from a set of courses.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"classes.wtf/datasource"
+)
+
+// A time when two courses meet at once, as returned by the API.
+type overlap struct {
+	Day       string `json:"day"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	StartDate string `json:"startDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+}
+
+func newOverlap(m datasource.Meeting) overlap {
+	o := overlap{
+		Day:   dayAbbrevs[m.Day],
+		Start: datasource.FormatClock(m.Start),
+		End:   datasource.FormatClock(m.End),
+	}
+	if !m.StartDate.IsZero() {
+		o.StartDate = m.StartDate.Format(datasource.DateLayout)
+	}
+	if !m.EndDate.IsZero() {
+		o.EndDate = m.EndDate.Format(datasource.DateLayout)
+	}
+	return o
+}
+
+// A pair of courses whose meetings overlap.
+type conflict struct {
+	Courses  [2]string `json:"courses"`
+	Overlaps []overlap `json:"overlaps"`
+}
+
+// Return every time that two sets of meetings overlap.
+func overlaps(a, b []datasource.Meeting) []overlap {
+	var result []overlap
+	for _, ma := range a {
+		for _, mb := range b {
+			if both, ok := ma.Intersect(mb); ok {
+				result = append(result, newOverlap(both))
+			}
+		}
+	}
+	return result
+}
+
+// Look up courses by ID, returning the courses found and the IDs not found.
+func (cat *catalog) lookup(ids []string) ([]datasource.Course, []string) {
+	courses := []datasource.Course{}
+	missing := []string{}
+	for _, id := range ids {
+		if course, ok := cat.vals[id]; ok {
+			courses = append(courses, course)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return courses, missing
+}
+
+// Serves conflicts between courses at "/schedule/conflicts". This takes a
+// POST request with a JSON body like {"ids": ["a", "b", "c"]}, and returns
+// every pair of the courses that meet at the same time.
+func (s *Server) serveConflicts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ids []string `json:"ids"`
+	}
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if len(req.Ids) > maxBulkCourses {
+		s.invalidParam(w, fmt.Errorf("too many ids: %d is more than %d", len(req.Ids), maxBulkCourses))
+		return
+	}
+	cat := s.loadedCatalog(w)
+	if cat == nil {
+		return
+	}
+	// Drop repeated IDs, so that a course isn't reported to conflict with itself.
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range req.Ids {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	courses, missing := cat.lookup(ids)
+	meetings := make([][]datasource.Meeting, len(courses))
+	for i, course := range courses {
+		meetings[i] = course.Meetings()
+	}
+	conflicts := []conflict{}
+	for i := range courses {
+		for j := i + 1; j < len(courses); j++ {
+			if o := overlaps(meetings[i], meetings[j]); len(o) > 0 {
+				conflicts = append(conflicts, conflict{
+					Courses:  [2]string{courses[i].Id, courses[j].Id},
+					Overlaps: o,
+				})
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"conflicts": conflicts,
+		"missing":   missing,
+	})
+}
+
+// Serves an iCalendar file with the meetings of courses given by ID, at
+// "/schedule/calendar.ics?ids=". This can be imported into calendar apps.
+func (s *Server) serveCalendar(w http.ResponseWriter, r *http.Request) {
+	ids, ok := s.idsParam(w, r, "ids", maxBulkCourses)
+	if !ok {
+		return
+	}
+	cat := s.loadedCatalog(w)
+	if cat == nil {
+		return
+	}
+	courses, missing := cat.lookup(ids)
+	if len(missing) > 0 {
+		writeError(w, http.StatusNotFound,
+			fmt.Errorf("courses not found: %s", strings.Join(missing, ", ")))
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="classes.ics"`)
+	if err := datasource.WriteCalendar(w, courses, time.Now()); err != nil {
+		slog.Warn("Failed to write calendar", "err", err)
+	}
+}
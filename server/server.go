@@ -2,198 +2,653 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
-	"github.com/antelman107/net-wait-go/wait"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
 
 	"classes.wtf/datasource"
 )
 
-// Provides access to a populated text search index.
-type TextSearch struct {
-	ctx  context.Context
-	rdb  *redis.Client
-	vals map[string]datasource.Course
-}
-
-func (ts *TextSearch) init(data []datasource.Course) error {
-	ts.rdb.Do(ts.ctx,
-		"FT.CREATE", "courses", "ON", "JSON", "PREFIX", "1", "course:", "NOOFFSETS",
-		"SCHEMA",
-		"$.title", "AS", "title", "TEXT", "WEIGHT", "2",
-		"$.description", "AS", "description", "TEXT",
-		"$.subject", "AS", "subject", "TEXT", "NOSTEM", "WEIGHT", "2",
-		"$.catalogNumber", "AS", "number", "TEXT", "NOSTEM", "WEIGHT", "2",
-		"$.semester", "AS", "semester", "TEXT",
-		"$.instructors..name", "AS", "instructor", "TEXT", "NOSTEM", "PHONETIC", "dm:en",
-		"$.component", "AS", "component", "TAG",
-		"$.level", "AS", "level", "TAG",
-		"$.academicYear", "AS", "academicYear", "NUMERIC",
-		"$.genEdArea", "AS", "genEdArea", "TAG",
-	)
-
-	pipe := ts.rdb.Pipeline()
-	ts.vals = make(map[string]datasource.Course)
-	for i, course := range data {
-		id := course.Id
-		s, err := json.Marshal(course)
-		if err != nil {
-			return fmt.Errorf("failed to marshal course id %v: %v", id, err)
-		}
-		if _, ok := ts.vals["course:"+id]; ok {
-			return fmt.Errorf("duplicate course id %v", id)
-		}
-		ts.vals["course:"+id] = course
-		pipe.Do(ts.ctx, "JSON.SET", "course:"+id, "$", s)
-		if i%4000 == 3999 || i == len(data)-1 {
-			if _, err := pipe.Exec(ts.ctx); err != nil {
-				return fmt.Errorf("error while adding data: %v", err)
-			}
-			pipe = ts.rdb.Pipeline()
+// A full-text search engine over course data, using the RediSearch query
+// language. Implementations must be safe for concurrent use.
+type searchBackend interface {
+	// Index a new set of courses, replacing the previously indexed courses.
+	// Queries continue to be served from the old courses until this returns.
+	index(data []datasource.Course, synonyms [][]string) error
+
+	// Execute a query, returning the total number of results and a slice of
+	// at most `limit` course IDs, starting from `offset`. The sort order must
+	// be one of `sortOrders`.
+	search(query, sort string, offset, limit int) (count int64, ids []string, err error)
+
+	// Count the results of a query grouped by each of the given fields, with
+	// counts in descending order. The fields must be among `facetFields`.
+	facets(query string, fields []string) (map[string][]facetCount, error)
+
+	// Return the number of courses in the active index. This also checks that
+	// the backend is reachable.
+	documents() (int64, error)
+}
+
+// Server handles HTTP requests using a search backend, along with in-memory
+// data about the indexed courses.
+type Server struct {
+	ctx     context.Context
+	backend searchBackend
+	cache   *queryCache // Cache of backend results, or nil if disabled.
+	metrics *metrics
+
+	limiter        *rateLimiter // Per-client rate limiter, or nil if disabled.
+	trustProxy     bool         // Read client addresses from proxy headers.
+	maxQueryLength int          // Maximum length of queries, or 0 for none.
+
+	mu  sync.RWMutex // Protects cat, which is swapped out on reload.
+	cat *catalog
+
+	reloadMu sync.Mutex // Held while loading new course data.
+	degraded int32      // Set atomically while the backend is being restored.
+}
+
+// In-memory data derived from the indexed courses. This is replaced as a
+// whole when course data is reloaded.
+type catalog struct {
+	vals         map[string]datasource.Course // Courses, keyed by ID.
+	descriptions map[string]string            // Plain text of each description.
+	offerings    map[offeringKey][]string     // Sections of each course offering.
+	version      string                       // Version of the course data.
+	loaded       time.Time                    // When the course data was loaded.
+	suggest      *suggester
+	vocab        *vocabulary
+	synonyms     [][]string // Synonym groups, kept for rebuilding the index.
+}
+
+func newCatalog(data []datasource.Course, version string, synonyms [][]string) (*catalog, error) {
+	vals := make(map[string]datasource.Course, len(data))
+	descriptions := make(map[string]string, len(data))
+	for _, course := range data {
+		if _, ok := vals[course.Id]; ok {
+			return nil, fmt.Errorf("duplicate course id %v", course.Id)
 		}
+		vals[course.Id] = course
+		descriptions[course.Id] = datasource.PlainText(course.Description)
 	}
-	return nil
+	return &catalog{
+		vals:         vals,
+		descriptions: descriptions,
+		offerings:    indexOfferings(data),
+		version:      version,
+		loaded:       time.Now(),
+		suggest:      newSuggester(data),
+		vocab:        newVocabulary(data),
+		synonyms:     synonyms,
+	}, nil
 }
 
-// Execute a full text query on the Redis server, using the query language.
-//
-// This function returns the total number of results in the query set, as well
-// as a slice of the first 100 document IDs.
-func (ts *TextSearch) search(query string) (count int64, results []string, err error) {
-	val, err := ts.rdb.Do(ts.ctx,
-		"FT.SEARCH", "courses", query,
-		"RETURN", "0", "LIMIT", "0", "100",
-	).Slice()
-	if err != nil {
-		return
+// Return the catalog for the currently indexed courses, or nil if course data
+// has not been loaded yet.
+func (s *Server) current() *catalog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cat
+}
+
+// Return the current catalog, or otherwise write an error response if course
+// data is still being loaded.
+func (s *Server) loadedCatalog(w http.ResponseWriter) *catalog {
+	cat := s.current()
+	if cat == nil {
+		s.metrics.observeError("unavailable")
+		writeError(w, http.StatusServiceUnavailable,
+			fmt.Errorf("course data is still loading, try again shortly"))
+	}
+	return cat
+}
+
+// Check that search is available, or otherwise write an error response.
+func (s *Server) checkAvailable(w http.ResponseWriter) bool {
+	if s.loadedCatalog(w) == nil {
+		return false
 	}
-	count = val[0].(int64)
-	for _, id := range val[1:] {
-		results = append(results, id.(string))
+	if atomic.LoadInt32(&s.degraded) != 0 {
+		s.metrics.observeError("unavailable")
+		writeError(w, http.StatusServiceUnavailable,
+			fmt.Errorf("search is degraded while the index is rebuilt, try again shortly"))
+		return false
 	}
-	return
+	return true
 }
 
-func (ts *TextSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+const (
+	// Number of results returned per page when the client does not ask.
+	defaultLimit = 100
+
+	// Maximum number of results that a client can request in one page.
+	maxLimit = 1000
+)
+
+// Orders that search results can be sorted in.
+var sortOrders = []string{"relevance", "newest", "oldest", "catalog", "title"}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAvailable(w) {
+		return
+	}
 	query := r.URL.Query().Get("q")
+	if !s.checkLength(w, "q", query) {
+		return
+	}
+	offset, err := intParam(r, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		s.invalidParam(w, err)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultLimit, 0, maxLimit)
+	if err != nil {
+		s.invalidParam(w, err)
+		return
+	}
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "relevance"
+	} else if !slices.Contains(sortOrders, sort) {
+		s.invalidParam(w, fmt.Errorf("unknown sort order %q", sort))
+		return
+	}
+	facetNames, err := parseFacets(r.URL.Query().Get("facets"))
+	if err != nil {
+		s.invalidParam(w, err)
+		return
+	}
+	wantHighlights, err := boolParam(r, "highlight")
+	if err != nil {
+		s.invalidParam(w, err)
+		return
+	}
+	cat := s.current()
+	busy, ok := s.busyParams(w, r, cat)
+	if !ok {
+		return
+	}
 	start := time.Now()
-	count, results, err := ts.search(query)
+	var count int64
+	var results []string
+	var hit, truncated bool
+	var facets map[string][]facetCount
+	if len(busy) > 0 {
+		// Offset, limit and facets apply to the courses left after filtering.
+		var fitting []*datasource.Course
+		fitting, hit, truncated, err = s.searchFitting(cat, query, sort, busy)
+		count = int64(len(fitting))
+		for _, course := range fitting[min(offset, len(fitting)):min(offset+limit, len(fitting))] {
+			results = append(results, course.Id)
+		}
+		if err == nil && len(facetNames) > 0 {
+			facets, err = countFacets(fitting, facetNames)
+		}
+	} else {
+		count, results, hit, err = s.search(query, sort, offset, limit)
+		if err == nil && len(facetNames) > 0 {
+			facets, err = s.facets(query, facetNames)
+		}
+	}
 	elapsed := time.Since(start)
-	log.Printf("Queried %q in %v", query, elapsed)
+	info := requestInfoFrom(r)
+	info.setQuery(query, count)
+	if s.cache != nil {
+		info.setCache(hit)
+	}
+	s.metrics.observeQuery(elapsed, count, err)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]any{
-			"error": err.Error(),
-		})
+		s.metrics.observeError("search")
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 	var courses []datasource.Course
 	for _, id := range results {
-		courses = append(courses, ts.vals[id])
+		if course, ok := cat.vals[id]; ok {
+			courses = append(courses, course)
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
+	resp := map[string]any{
 		"count":   count,
 		"courses": courses,
+		"offset":  offset,
+		"limit":   limit,
+		"sort":    sort,
 		"time":    elapsed.Seconds(),
-	})
+	}
+	if facets != nil {
+		resp["facets"] = facets
+	}
+	if truncated {
+		resp["truncated"] = true
+	}
+	if count == 0 {
+		if corrected, ok := cat.vocab.correctQuery(query); ok {
+			// Only suggest the corrected query if it actually has results,
+			// after filtering by the same schedule as this query.
+			var n int64
+			if len(busy) > 0 {
+				var fitting []*datasource.Course
+				fitting, _, _, err = s.searchFitting(cat, corrected, "relevance", busy)
+				n = int64(len(fitting))
+			} else {
+				n, _, _, err = s.search(corrected, "relevance", 0, 0)
+			}
+			if err == nil && n > 0 {
+				resp["suggestion"] = map[string]any{
+					"query": corrected,
+					"count": n,
+				}
+			}
+		}
+	}
+	if wantHighlights {
+		terms := queryTerms(query)
+		highlights := make([]highlight, len(courses))
+		for i, course := range courses {
+			highlights[i] = cat.highlight(course, terms)
+		}
+		resp["highlights"] = highlights
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
-// Run spawns the backend server. This listens on port 7500 for HTTP requests,
-// and it also creates an in-memory Redis instance in the background at port
-// 7501 for text search.
-func Run(uri string, static string, local bool) {
-	log.Printf("Starting Redis server...")
-	var proc *exec.Cmd
-	if local {
-		exec.Command("docker", "kill", "classes.wtf-redis").Run()
-		proc = exec.Command("docker", "run", "--name", "classes.wtf-redis",
-			"-i", "--rm", "-p", "7501:6379", "redis/redis-stack-server:7.0.6-RC8",
-			"redis-stack-server", "--save", "")
-	} else {
-		proc = exec.Command("redis-server",
-			"--loadmodule", "/opt/redis-stack/lib/redisearch.so",
-			"--loadmodule", "/opt/redis-stack/lib/rejson.so",
-			"--port", "7501", "--save", "")
+// Maximum number of courses that can be fetched at once with /courses.
+const maxBulkCourses = 1000
+
+// Serves a single course by ID, at "/course/{id}".
+func (s *Server) serveCourse(w http.ResponseWriter, r *http.Request) {
+	cat := s.loadedCatalog(w)
+	if cat == nil {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/course/")
+	course, ok := cat.vals[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("course %q not found", id))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(course)
+}
+
+// Serves courses by a comma-separated list of IDs, at "/courses?ids=".
+//
+// Courses are returned in the order requested, and unknown IDs are listed
+// separately rather than causing an error.
+func (s *Server) serveCourses(w http.ResponseWriter, r *http.Request) {
+	ids, ok := s.idsParam(w, r, "ids", maxBulkCourses)
+	if !ok {
+		return
 	}
-	proc.Stdout = os.Stdout
-	proc.Stderr = os.Stderr
+	cat := s.loadedCatalog(w)
+	if cat == nil {
+		return
+	}
+	courses, missing := cat.lookup(ids)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"courses": courses,
+		"missing": missing,
+	})
+}
 
-	if err := proc.Start(); err != nil {
-		log.Fatalf("failed to start redis: %v", err)
+// Parse a query parameter with comma-separated course IDs, skipping empty ones,
+// or otherwise write an error response if there are more than max IDs.
+func (s *Server) idsParam(w http.ResponseWriter, r *http.Request, name string, max int) ([]string, bool) {
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get(name), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
 	}
+	if len(ids) > max {
+		s.invalidParam(w,
+			fmt.Errorf("too many %s: %d is more than %d", name, len(ids), max))
+		return nil, false
+	}
+	return ids, true
+}
 
-	if !wait.New().Do([]string{"localhost:7501"}) {
-		log.Fatalf("failed to connect to redis")
+// Parse an integer query parameter, falling back to a default if missing.
+func intParam(r *http.Request, name string, def, min, max int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
 	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q is not an integer", name, s)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("invalid %s: %d is not between %d and %d", name, n, min, max)
+	}
+	return n, nil
+}
 
-	log.Printf("Reading course data...")
-	data, err := readData(uri)
+// Parse a boolean query parameter, which is false if missing.
+func boolParam(r *http.Request, name string) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
 	if err != nil {
-		log.Fatalf("could not fetch data: %v", err)
+		return false, fmt.Errorf("invalid %s: %q is not a boolean", name, s)
+	}
+	return b, nil
+}
+
+// Maximum size of a JSON request body.
+const maxBodyBytes = 1 << 20
+
+// Decode the JSON body of a POST request, or otherwise write an error response.
+func (s *Server) readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return false
 	}
-	log.Printf("Found %v courses", len(data))
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(v); err != nil {
+		s.invalidParam(w, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// Write an error response for an invalid request parameter.
+func (s *Server) invalidParam(w http.ResponseWriter, err error) {
+	s.metrics.observeError("invalid_param")
+	writeError(w, http.StatusBadRequest, err)
+}
+
+// Write a JSON error response with the given status code.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": err.Error(),
+	})
+}
+
+// Config holds the options for running the backend server.
+type Config struct {
+	Data     string // Path or URL for the course data file.
+	Static   string // Path to static website files, if any.
+	Local    bool   // Run Redis with Docker, for local development.
+	Synonyms string // Path to a file of synonym groups, if any.
+	Backend  string // Search backend to use, either "redis" or "memory".
+
+	Addr         string   // Address to listen on for HTTP requests.
+	RedisPort    int      // Port for the Redis subprocess to listen on.
+	RedisImage   string   // Docker image for Redis, used in local mode.
+	RedisModules []string // Paths of Redis modules, used outside local mode.
+
+	// RedisAddr is the address of an existing Redis Stack server to index into.
+	// If set, no Redis subprocess is started, and the options above are unused.
+	RedisAddr      string
+	RedisPassword  string // Password for RedisAddr, if any.
+	RedisTLS       bool   // Connect to RedisAddr over TLS.
+	RedisNamespace string // Prefix for index names and keys, like "classes:".
+
+	// AdminToken enables the /admin/reload endpoint, authenticated with this
+	// bearer token. The endpoint is disabled if the token is empty.
+	AdminToken string
+
+	// ReloadInterval is how often to reload course data, or 0 to disable.
+	ReloadInterval time.Duration
+
+	// CacheSize is the number of course IDs and facet values in cached query
+	// results, or 0 to disable the cache.
+	CacheSize int
+
+	// RateLimit is the number of API requests per second allowed from each
+	// client, with bursts of up to RateBurst. Rate limiting is disabled if 0.
+	RateLimit float64
+	RateBurst int
+
+	// TrustProxy reads client IP addresses from the Fly-Client-IP and
+	// X-Forwarded-For headers, which must be set by a trusted proxy.
+	TrustProxy bool
+
+	// MaxQueryLength is the maximum length of a query in bytes, or 0 for none.
+	MaxQueryLength int
+}
+
+// Maximum size of request headers, including the URL and its query string.
+const maxHeaderBytes = 64 << 10
+
+// How long to wait for in-flight requests to finish when shutting down.
+const shutdownTimeout = 3 * time.Second
+
+// Run spawns the backend server. This listens on the configured address for
+// HTTP requests. With the Redis backend, it also creates an in-memory Redis
+// instance in the background for text search, unless an external Redis
+// address is given.
+//
+// The server runs until it receives SIGINT or SIGTERM, then drains in-flight
+// requests and stops Redis before returning. It returns an error if it fails
+// to start or if it could not shut down cleanly.
+func Run(config Config) error {
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	rdb := redis.NewClient(&redis.Options{Addr: "localhost:7501"})
-	ts := &TextSearch{ctx, rdb, nil}
+	s := &Server{
+		ctx:            ctx,
+		metrics:        newMetrics(),
+		trustProxy:     config.TrustProxy,
+		maxQueryLength: config.MaxQueryLength,
+	}
+	if config.CacheSize > 0 {
+		s.cache = newQueryCache(config.CacheSize)
+	}
+	if config.RateLimit > 0 {
+		s.limiter = newRateLimiter(config.RateLimit, config.RateBurst)
+		go s.sweepRateLimiter()
+	}
+	var supervisor *redisSupervisor
+	switch config.Backend {
+	case "memory":
+		slog.Info("Using in-process search backend")
+		s.backend = newMemSearch()
 
-	log.Printf("Indexing course data...")
-	start := time.Now()
-	if err := ts.init(data); err != nil {
-		log.Fatalf("faild to index data: %v", err)
+	case "redis", "":
+		// Replies are parsed as RESP2 arrays, which RESP3 would turn into maps.
+		redisOpts := &redis.Options{Addr: config.RedisAddr, Password: config.RedisPassword, Protocol: 2}
+		if config.RedisTLS {
+			redisOpts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if config.RedisAddr == "" {
+			slog.Info("Starting Redis server")
+			var err error
+			supervisor, err = startSupervisor(redisOptions{
+				port:    config.RedisPort,
+				local:   config.Local,
+				image:   config.RedisImage,
+				modules: config.RedisModules,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to start redis: %v", err)
+			}
+			defer supervisor.stop()
+			redisOpts.Addr = supervisor.opts.addr()
+		} else {
+			slog.Info("Connecting to Redis", "addr", config.RedisAddr)
+		}
+
+		rdb := redis.NewClient(redisOpts)
+		defer rdb.Close()
+		ts := &TextSearch{ctx: ctx, rdb: rdb, namespace: config.RedisNamespace}
+		if err := ts.dropStale(); err != nil {
+			return fmt.Errorf("failed to connect to redis: %v", err)
+		}
+		s.backend = ts
+
+		if supervisor != nil {
+			go supervisor.watch(
+				func(err error) {
+					slog.Error("Redis exited unexpectedly, restarting", "err", err)
+					atomic.StoreInt32(&s.degraded, 1)
+				},
+				func(lost <-chan struct{}) {
+					ts.forget() // The index was lost along with Redis.
+					for delay := time.Second; ; delay *= 2 {
+						err := s.restore()
+						if err == nil {
+							atomic.StoreInt32(&s.degraded, 0)
+							return
+						}
+						if delay > maxRestartDelay {
+							delay = maxRestartDelay
+						}
+						slog.Error("Failed to rebuild index after restarting Redis", "err", err, "retry", delay)
+						select {
+						case <-time.After(delay):
+						case <-lost:
+							return // Redis exited again, so it will be restarted.
+						case <-ctx.Done():
+							return
+						}
+					}
+				},
+			)
+		}
+
+	default:
+		return fmt.Errorf("unknown search backend %q", config.Backend)
 	}
-	log.Printf("Finished indexing data in %v", time.Since(start))
 
-	log.Printf("Listening at http://localhost:7500")
-	http.Handle("/search", gziphandler.GzipHandler(ts))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" && static != "" {
-			http.ServeFile(w, r, path.Join(static, "index.html"))
+	mux := http.NewServeMux()
+	api := func(h http.Handler) http.Handler {
+		return s.rateLimited(gziphandler.GzipHandler(h))
+	}
+	mux.Handle("/search", api(s))
+	mux.Handle("/suggest", api(http.HandlerFunc(s.serveSuggest)))
+	mux.Handle("/course/", api(http.HandlerFunc(s.serveCourse)))
+	mux.Handle("/courses", api(http.HandlerFunc(s.serveCourses)))
+	mux.Handle("/similar", api(http.HandlerFunc(s.serveSimilar)))
+	mux.Handle("/schedule/conflicts", api(http.HandlerFunc(s.serveConflicts)))
+	mux.Handle("/schedule/generate", api(http.HandlerFunc(s.serveGenerate)))
+	mux.Handle("/schedule/calendar.ics", api(http.HandlerFunc(s.serveCalendar)))
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/healthz", s.serveHealth)
+	mux.HandleFunc("/readyz", s.serveReady)
+	if config.AdminToken != "" {
+		mux.Handle("/admin/reload", s.reloadHandler(config.Data, config.Synonyms, config.AdminToken))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && config.Static != "" {
+			http.ServeFile(w, r, path.Join(config.Static, "index.html"))
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 		}
 	})
-	if static != "" {
+	if config.Static != "" {
 		staticFiles := gziphandler.GzipHandler(
-			http.FileServer(http.Dir(path.Join(static, "assets"))))
-		http.Handle("/assets/", http.StripPrefix("/assets", staticFiles))
+			http.FileServer(http.Dir(path.Join(config.Static, "assets"))))
+		mux.Handle("/assets/", http.StripPrefix("/assets", staticFiles))
+	}
+
+	// Start listening before loading data, so that health checks can see the
+	// server while it is still indexing. Until then, /readyz reports that the
+	// server is not ready, and queries get a 503 response.
+	slog.Info("Listening", "url", displayAddr(config.Addr))
+	srv := &http.Server{Addr: config.Addr, Handler: s.logRequests(mux), MaxHeaderBytes: maxHeaderBytes}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	loaded := make(chan error, 1)
+	go func() {
+		_, err := s.load(config.Data, config.Synonyms)
+		loaded <- err
+	}()
+	select {
+	case err := <-loaded:
+		if err != nil {
+			srv.Close()
+			return fmt.Errorf("failed to load data: %v", err)
+		}
+	case err := <-serveErr:
+		return err
+	case <-signals.Done():
+		srv.Close()
+		return fmt.Errorf("interrupted while loading data")
+	}
+	if config.ReloadInterval > 0 {
+		go s.reloadPeriodically(config.Data, config.Synonyms, config.ReloadInterval)
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-signals.Done():
+		stopSignals() // A second signal will now terminate immediately.
+	}
+
+	slog.Info("Shutting down, waiting for requests to finish", "timeout", shutdownTimeout)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to finish in-flight requests: %v", err)
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// Format a listen address as a URL for logging, like "http://localhost:7500".
+func displayAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "http://localhost" + addr
 	}
-	log.Fatal(http.ListenAndServe(":7500", nil))
+	return "http://" + addr
 }
 
-func readData(uri string) (data []datasource.Course, err error) {
+// Read course data from a path or URL. This also returns a version string for
+// the dataset, which is a short hash of its contents.
+func readData(uri string) (data []datasource.Course, version string, err error) {
 	var buf []byte
 	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
 		resp, err := http.Get(uri)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		defer resp.Body.Close()
 		buf, err = io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	} else {
 		buf, err = os.ReadFile(uri)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	}
+	sum := sha256.Sum256(buf)
+	version = hex.EncodeToString(sum[:6])
 	err = json.Unmarshal(buf, &data)
 	return
 }
@@ -0,0 +1,135 @@
+// Recommendations of courses similar to a given course.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"classes.wtf/datasource"
+)
+
+const (
+	// Number of similar courses returned when the client does not ask.
+	defaultSimilar = 10
+
+	// Maximum number of similar courses that a client can request.
+	maxSimilar = 50
+
+	// Number of distinctive words from a course used to find similar courses.
+	similarTerms = 16
+)
+
+// Build a query for courses similar to the given course.
+//
+// This picks the most distinctive words in the title and description by their
+// TF-IDF score, and lets the search index rank courses that share them. Other
+// offerings of the same course are excluded.
+func (cat *catalog) similarQuery(course datasource.Course) string {
+	counts := make(map[string]int)
+	for _, word := range contentWords(course.Title) {
+		counts[word] += 2 // Matches the title's weight in the index.
+	}
+	for _, word := range contentWords(cat.descriptions[course.Id]) {
+		counts[word]++
+	}
+	scores := make(map[string]float64, len(counts))
+	words := make([]string, 0, len(counts))
+	for word, count := range counts {
+		df := cat.vocab.freqs[word]
+		if df < 2 {
+			continue // Unique to this course, so it can't be shared.
+		}
+		scores[word] = float64(count) * math.Log(float64(cat.vocab.docs)/float64(df))
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if scores[words[i]] != scores[words[j]] {
+			return scores[words[i]] > scores[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > similarTerms {
+		words = words[:similarTerms]
+	}
+
+	query := "(" + strings.Join(words, "|") + ")"
+	if course.Subject != "" {
+		query += " ~@subject:" + escapeQuery(course.Subject)
+	}
+	for i, instructor := range course.Instructors {
+		if i == 3 {
+			break
+		}
+		if names := splitWords(instructor.Name); len(names) > 0 {
+			query += ` ~@instructor:"` + strings.Join(names, " ") + `"`
+		}
+	}
+	query += fmt.Sprintf(" -@externalId:[%d %d]", course.ExternalId, course.ExternalId)
+	return query
+}
+
+// Escape punctuation in a string so that it is matched literally in a query.
+func escapeQuery(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if !isWordRune(r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Serves courses similar to a given course, at "/similar?id=".
+func (s *Server) serveSimilar(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAvailable(w) {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	limit, err := intParam(r, "limit", defaultSimilar, 0, maxSimilar)
+	if err != nil {
+		s.invalidParam(w, err)
+		return
+	}
+	cat := s.current()
+	course, ok := cat.vals[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("course %q not found", id))
+		return
+	}
+
+	courses := []datasource.Course{}
+	if query := cat.similarQuery(course); query != "" && limit > 0 {
+		// Fetch extra results, since several may be offerings of one course.
+		_, results, _, err := s.search(query, "relevance", 0, 4*limit)
+		if err != nil {
+			s.metrics.observeError("search")
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		seen := make(map[uint32]bool)
+		for _, id := range results {
+			similar, ok := cat.vals[id]
+			if !ok || seen[similar.ExternalId] {
+				continue
+			}
+			seen[similar.ExternalId] = true
+			courses = append(courses, similar)
+			if len(courses) == limit {
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"courses": courses,
+	})
+}
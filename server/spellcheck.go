@@ -0,0 +1,102 @@
+// Spelling correction of queries against the vocabulary of the index.
+
+package server
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Find the closest word in the vocabulary to a misspelled word, preferring the
+// most common word among those at the smallest edit distance.
+func (v *vocabulary) correct(word string) (string, bool) {
+	maxDist := 2
+	if len(word) <= 4 {
+		maxDist = 1
+	}
+	best, bestDist, bestFreq := "", maxDist+1, 0
+	for n := len(word) - maxDist; n <= len(word)+maxDist; n++ {
+		if n <= 0 || n >= len(v.byLength) {
+			continue
+		}
+		for _, candidate := range v.byLength[n] {
+			// The distance is capped just above the best so far, so that a
+			// returned tie is a real tie.
+			dist := editDistance(word, candidate, bestDist+1)
+			if dist > maxDist {
+				continue
+			}
+			freq := v.freqs[candidate]
+			if dist < bestDist || dist == bestDist && freq > bestFreq {
+				best, bestDist, bestFreq = candidate, dist, freq
+			}
+		}
+	}
+	return best, best != ""
+}
+
+// Compute the Levenshtein distance between two words, stopping early and
+// returning max if the distance is known to be at least max.
+func editDistance(a, b string, max int) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin >= max {
+			return max
+		}
+		prev, curr = curr, prev
+	}
+	if prev[len(b)] > max {
+		return max
+	}
+	return prev[len(b)]
+}
+
+// Rewrite a query with misspelled words replaced by their closest match in the
+// vocabulary. Returns false if no words needed to be corrected.
+func (v *vocabulary) correctQuery(query string) (string, bool) {
+	var sb strings.Builder
+	changed := false
+	last := 0
+	for _, tok := range lexQuery(query) {
+		if tok.kind != tokenWord || tok.prefix {
+			continue
+		}
+		word := strings.ToLower(tok.text)
+		if len(splitWords(word)) != 1 {
+			continue // Hyphenated words, like "S-101", are left alone.
+		}
+		if v.freqs[word] > 0 || stopWords[word] || strings.IndexFunc(word, unicode.IsLetter) == -1 {
+			continue
+		}
+		if corrected, ok := v.correct(word); ok {
+			sb.WriteString(query[last:tok.start])
+			sb.WriteString(corrected)
+			last = tok.end
+			changed = true
+		}
+	}
+	sb.WriteString(query[last:])
+	return sb.String(), changed
+}
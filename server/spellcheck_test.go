@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"classes.wtf/datasource"
+)
+
+func TestCorrect(t *testing.T) {
+	var data []datasource.Course
+	add := func(title string, copies int) {
+		for i := 0; i < copies; i++ {
+			data = append(data, datasource.Course{Title: title})
+		}
+	}
+	// Frequent words that are far from the typos below should never win.
+	add("Introduction Programming Economics", 50)
+	add("Psychology Philosophy", 20)
+	add("Linear Algebra", 5)
+	add("Organic Chemistry", 3)
+	add("Physics Physiology", 1)
+	add("Music", 2)
+	v := newVocabulary(data)
+
+	tests := []struct {
+		word string
+		want string
+		ok   bool
+	}{
+		{"algebar", "algebra", true},
+		{"linaer", "linear", true},
+		{"chemsitry", "chemistry", true},
+		{"economcs", "economics", true},
+		{"phisics", "physics", true},
+		{"psycology", "psychology", true},
+		{"musc", "music", true},
+		{"orgnic", "organic", true},
+		{"xyzzyplugh", "", false},
+		{"qqqq", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := v.correct(tt.word)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("correct(%q) = %q, %v; want %q, %v", tt.word, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"kitten", "sitting", 10, 3},
+		{"kitten", "sitting", 2, 2},
+		{"abc", "abc", 1, 0},
+		{"abc", "abd", 5, 1},
+		{"", "abc", 5, 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
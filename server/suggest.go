@@ -0,0 +1,170 @@
+// Autocomplete suggestions for subjects, instructors, and course titles.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"classes.wtf/datasource"
+)
+
+const (
+	// Number of suggestions returned when the client does not ask.
+	defaultSuggestions = 10
+
+	// Maximum number of suggestions that a client can request.
+	maxSuggestions = 50
+)
+
+// A completion that can be suggested for a search prefix.
+type suggestion struct {
+	Kind  string `json:"kind"`  // One of "subject", "instructor", or "title".
+	Value string `json:"value"` // Text of the completion, for use in a query.
+	Label string `json:"label"` // Human-readable text to display.
+	Count int    `json:"count"` // Number of courses that this completion matches.
+}
+
+// An entry in the sorted list of lowercased strings to match prefixes against.
+type suggestKey struct {
+	key     string
+	index   int  // Index of the suggestion in the suggester.
+	leading bool // Set if the key is the start of the suggestion's text.
+}
+
+// Provides prefix completions, built once from course data at index time.
+//
+// Each suggestion is keyed by its full text and by every word boundary within
+// it, so that "sci" completes both "COMPSCI" and "Computer Science".
+type suggester struct {
+	entries []suggestion
+	keys    []suggestKey
+}
+
+func newSuggester(data []datasource.Course) *suggester {
+	s := &suggester{}
+	indices := make(map[string]int)
+	add := func(kind, value, label string, texts ...string) {
+		id := kind + "\x00" + value
+		i, ok := indices[id]
+		if !ok {
+			i = len(s.entries)
+			indices[id] = i
+			s.entries = append(s.entries, suggestion{Kind: kind, Value: value})
+			for _, text := range texts {
+				s.addKeys(text, i)
+			}
+		}
+		s.entries[i].Label = label
+		s.entries[i].Count++
+	}
+	for _, course := range data {
+		if course.Subject != "" {
+			add("subject", course.Subject, course.Subject+" — "+course.SubjectDescription,
+				course.Subject, course.SubjectDescription)
+		}
+		for _, instructor := range course.Instructors {
+			if instructor.Name != "" {
+				add("instructor", instructor.Name, instructor.Name, instructor.Name)
+			}
+		}
+		if course.Title != "" {
+			add("title", course.Title, course.Title, course.Title)
+		}
+	}
+	sort.Slice(s.keys, func(i, j int) bool {
+		return s.keys[i].key < s.keys[j].key
+	})
+	return s
+}
+
+// Add keys for each word boundary in the text, pointing to a suggestion.
+func (s *suggester) addKeys(text string, index int) {
+	text = normalizeWords(text)
+	for i := 0; i < len(text); i++ {
+		if i == 0 || text[i-1] == ' ' {
+			s.keys = append(s.keys, suggestKey{text[i:], index, i == 0})
+		}
+	}
+}
+
+// Return the best suggestions for a prefix. Matches at the start of a
+// suggestion are ranked first, then suggestions matching more courses.
+func (s *suggester) suggest(prefix string, limit int) []suggestion {
+	prefix = normalizePrefix(prefix)
+	results := []suggestion{}
+	if prefix == "" {
+		return results
+	}
+
+	leading := make(map[int]bool)
+	i := sort.Search(len(s.keys), func(i int) bool {
+		return s.keys[i].key >= prefix
+	})
+	for ; i < len(s.keys) && strings.HasPrefix(s.keys[i].key, prefix); i++ {
+		key := s.keys[i]
+		leading[key.index] = leading[key.index] || key.leading
+	}
+
+	indices := make([]int, 0, len(leading))
+	for index := range leading {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		a, b := indices[i], indices[j]
+		if leading[a] != leading[b] {
+			return leading[a]
+		}
+		if s.entries[a].Count != s.entries[b].Count {
+			return s.entries[a].Count > s.entries[b].Count
+		}
+		return s.entries[a].Value < s.entries[b].Value
+	})
+	for _, index := range indices {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, s.entries[index])
+	}
+	return results
+}
+
+// Lowercase text and collapse runs of whitespace and punctuation.
+func normalizeWords(text string) string {
+	return strings.Join(splitWords(strings.ToLower(text)), " ")
+}
+
+// Normalize a prefix like normalizeWords, but keep a trailing separator since
+// it means that the last word is complete.
+func normalizePrefix(prefix string) string {
+	text := normalizeWords(prefix)
+	if last, _ := utf8.DecodeLastRuneInString(prefix); text != "" && !isWordRune(last) {
+		text += " "
+	}
+	return text
+}
+
+func (s *Server) serveSuggest(w http.ResponseWriter, r *http.Request) {
+	limit, err := intParam(r, "limit", defaultSuggestions, 0, maxSuggestions)
+	if err != nil {
+		s.invalidParam(w, err)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	if !s.checkLength(w, "prefix", prefix) {
+		return
+	}
+	cat := s.loadedCatalog(w)
+	if cat == nil {
+		return
+	}
+	suggestions := cat.suggest.suggest(prefix, limit)
+	requestInfoFrom(r).setQuery(prefix, int64(len(suggestions)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"suggestions": suggestions,
+	})
+}
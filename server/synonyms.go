@@ -0,0 +1,170 @@
+// Synonym groups for search terms, loaded from a file at startup.
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Read synonym groups from a file, which has one comma-separated group per
+// line. Blank lines and lines starting with "#" are ignored.
+func readSynonyms(filename string) ([][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var groups [][]string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var group []string
+		for _, term := range strings.Split(text, ",") {
+			if term = normalizeWords(term); term != "" {
+				group = append(group, term)
+			}
+		}
+		if len(group) < 2 {
+			return nil, fmt.Errorf("%s:%d: synonym group needs at least two terms", filename, line)
+		}
+		groups = append(groups, group)
+	}
+	return groups, scanner.Err()
+}
+
+// Register synonym groups with the index. This must be called before any
+// documents are added, so they are indexed with their synonym groups.
+//
+// RediSearch only supports synonyms between single words, so multi-word
+// phrases are instead recorded to be expanded in queries by expandSynonyms,
+// in both directions.
+func (ts *TextSearch) initSynonyms(idx *redisIndex, groups [][]string) error {
+	idx.expansions = make(map[string][]string)
+	for i, group := range groups {
+		args := []any{"FT.SYNUPDATE", idx.name, fmt.Sprintf("group%d", i)}
+		var words, phrases []string
+		for _, term := range group {
+			if strings.Contains(term, " ") {
+				phrases = append(phrases, term)
+			} else {
+				words = append(words, term)
+				args = append(args, term)
+			}
+		}
+		if len(words) > 1 {
+			if err := ts.rdb.Do(ts.ctx, args...).Err(); err != nil {
+				return fmt.Errorf("failed to add synonym group %v: %v", group, err)
+			}
+		}
+		for _, word := range words {
+			idx.expansions[word] = append(idx.expansions[word], phrases...)
+		}
+		for _, phrase := range phrases {
+			idx.expansions[phrase] = append(idx.expansions[phrase], otherTerms(group, phrase)...)
+		}
+	}
+	return nil
+}
+
+// Return the terms of a synonym group other than the given one.
+func otherTerms(group []string, term string) []string {
+	var others []string
+	for _, other := range group {
+		if other != term {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+// Rewrite a query so that words and phrases with multi-word synonyms also
+// match the rest of their group, for example "ml" becomes (ml|"machine
+// learning"), and "machine learning" becomes ((machine learning)|ml).
+func (idx *redisIndex) expandSynonyms(query string) string {
+	if idx == nil {
+		return query
+	}
+	return expandQuery(query, idx.expansions)
+}
+
+// Rewrite a query so that each word or phrase that is a key of expansions,
+// which must be normalized by normalizeWords, also matches its values. Phrases
+// can be quoted, or just consecutive words.
+func expandQuery(query string, expansions map[string][]string) string {
+	if len(expansions) == 0 {
+		return query
+	}
+	tokens := lexQuery(query)
+	var sb strings.Builder
+	last := 0
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind == tokenPhrase {
+			if others := expansions[normalizeWords(tok.text)]; len(others) > 0 {
+				sb.WriteString(query[last:tok.start])
+				writeExpansion(&sb, query[tok.start:tok.end], others)
+				last = tok.end
+			}
+			continue
+		}
+		if tok.kind != tokenWord || tok.prefix {
+			continue
+		}
+
+		// Find the longest run of words starting here that has synonyms. Runs
+		// after a modifier like "-" or "@title:" are skipped, since grouping
+		// them would change which words the modifier applies to.
+		end := i
+		others := expansions[normalizeWords(tok.text)]
+		if i == 0 || !isModifier(tokens[i-1]) {
+			words := normalizeWords(tok.text)
+			for j := i + 1; j < len(tokens); j++ {
+				next := tokens[j]
+				if next.kind != tokenWord || next.prefix || strings.TrimSpace(query[tokens[j-1].end:next.start]) != "" {
+					break
+				}
+				words += " " + normalizeWords(next.text)
+				if phrase := expansions[words]; len(phrase) > 0 {
+					end, others = j, phrase
+				}
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		text := query[tok.start:tokens[end].end]
+		if end > i {
+			text = "(" + text + ")"
+		}
+		sb.WriteString(query[last:tok.start])
+		writeExpansion(&sb, text, others)
+		last = tokens[end].end
+		i = end
+	}
+	sb.WriteString(query[last:])
+	return sb.String()
+}
+
+// Reports whether a token changes the meaning of the term after it.
+func isModifier(tok token) bool {
+	return tok.kind == tokenField || tok.kind == tokenPunct && strings.Contains("-~%", tok.text)
+}
+
+// Write a union of a term from a query with its synonyms.
+func writeExpansion(sb *strings.Builder, term string, others []string) {
+	sb.WriteString("(" + term)
+	for _, other := range others {
+		if strings.Contains(other, " ") {
+			other = `"` + other + `"`
+		}
+		sb.WriteString("|" + other)
+	}
+	sb.WriteString(")")
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"classes.wtf/datasource"
+)
+
+func TestExpandQuery(t *testing.T) {
+	expansions := map[string][]string{
+		"ml":               {"machine learning"},
+		"machine learning": {"ml"},
+		"cs":               {"computer science"},
+		"computer science": {"cs", "compsci"},
+	}
+	tests := []struct {
+		query, want string
+	}{
+		{"ml", `(ml|"machine learning")`},
+		{"ML theory", `(ML|"machine learning") theory`},
+		{"-ml", `-(ml|"machine learning")`},
+		{"@title:ml", `@title:(ml|"machine learning")`},
+		{"ml*", "ml*"},
+		{"machine learning", "((machine learning)|ml)"},
+		{"intro to Machine  Learning", "intro to ((Machine  Learning)|ml)"},
+		{`"machine learning"`, `("machine learning"|ml)`},
+		{`-"machine learning"`, `-("machine learning"|ml)`},
+		{"(computer science | math)", "(((computer science)|cs|compsci) | math)"},
+		{"machine learning computer science", "((machine learning)|ml) ((computer science)|cs|compsci)"},
+
+		// Modifiers only apply to the first word, so the phrase is left alone.
+		{"-machine learning", "-machine learning"},
+		{"@title:machine learning", "@title:machine learning"},
+		{"machine learn*", "machine learn*"},
+		{"machine, learning", "machine, learning"},
+		{"machine", "machine"},
+	}
+	for _, tt := range tests {
+		if got := expandQuery(tt.query, expansions); got != tt.want {
+			t.Errorf("expandQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMemSearchSynonyms(t *testing.T) {
+	ms := newMemSearch()
+	data := []datasource.Course{
+		{Id: "abbrev", Title: "Topics in ML"},
+		{Id: "phrase", Title: "Machine Learning Theory"},
+		{Id: "reversed", Title: "Learning about Machines"},
+		{Id: "cs", Title: "Great Ideas in Computer Science"},
+	}
+	synonyms := [][]string{{"ml", "machine learning"}, {"cs", "compsci", "computer science"}}
+	if err := ms.index(data, synonyms); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"ml", []string{"abbrev", "phrase"}},
+		{"machine learning", []string{"abbrev", "phrase", "reversed"}},
+		{`"machine learning"`, []string{"abbrev", "phrase"}},
+		{"machine learning theory", []string{"phrase"}},
+		{"compsci", []string{"cs"}},
+		{"computer science", []string{"cs"}},
+	}
+	for _, tt := range tests {
+		_, ids, err := ms.search(tt.query, "relevance", 0, 10)
+		if err != nil {
+			t.Errorf("search(%q) failed: %v", tt.query, err)
+			continue
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("search(%q) = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Vocabulary statistics for the words that appear in course data.
+
+package server
+
+import (
+	"strings"
+	"unicode"
+
+	"classes.wtf/datasource"
+)
+
+// Common English words that carry no meaning for similarity.
+var stopWords = map[string]bool{
+	"a": true, "about": true, "all": true, "also": true, "an": true, "and": true,
+	"any": true, "are": true, "as": true, "at": true, "be": true, "been": true,
+	"but": true, "by": true, "can": true, "course": true, "do": true, "each": true,
+	"for": true, "from": true, "has": true, "have": true, "how": true, "i": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "may": true,
+	"more": true, "not": true, "of": true, "on": true, "or": true, "our": true,
+	"other": true, "s": true, "such": true, "than": true, "that": true, "the": true,
+	"their": true, "them": true, "these": true, "they": true, "this": true,
+	"those": true, "through": true, "to": true, "was": true, "we": true, "what": true,
+	"when": true, "which": true, "will": true, "with": true, "within": true,
+	"would": true, "you": true, "your": true,
+}
+
+// Split text into lowercased words, dropping stop words and numbers.
+func contentWords(text string) []string {
+	var words []string
+	for _, word := range splitWords(strings.ToLower(text)) {
+		if stopWords[word] || strings.IndexFunc(word, unicode.IsLetter) == -1 {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// Document frequencies of the words in all courses, built at index time.
+type vocabulary struct {
+	docs     int            // Total number of courses.
+	freqs    map[string]int // Number of courses containing each word.
+	byLength [][]string     // Words in the vocabulary, grouped by length.
+}
+
+func newVocabulary(data []datasource.Course) *vocabulary {
+	v := &vocabulary{docs: len(data), freqs: make(map[string]int)}
+	for _, course := range data {
+		seen := make(map[string]bool)
+		for _, word := range courseWords(course) {
+			if !seen[word] {
+				seen[word] = true
+				v.freqs[word]++
+			}
+		}
+	}
+	for word := range v.freqs {
+		for len(v.byLength) <= len(word) {
+			v.byLength = append(v.byLength, nil)
+		}
+		v.byLength[len(word)] = append(v.byLength[len(word)], word)
+	}
+	return v
+}
+
+// List the words in the searchable text fields of a course.
+func courseWords(course datasource.Course) []string {
+	words := contentWords(course.Title)
+	words = append(words, contentWords(datasource.PlainText(course.Description))...)
+	words = append(words, contentWords(course.Subject)...)
+	words = append(words, contentWords(course.SubjectDescription)...)
+	for _, instructor := range course.Instructors {
+		words = append(words, contentWords(instructor.Name)...)
+	}
+	return words
+}
@@ -8,6 +8,10 @@ export default defineConfig({
   server: {
     proxy: {
       "/search": "http://localhost:7500",
+      "/suggest": "http://localhost:7500",
+      "/course": "http://localhost:7500",
+      "/similar": "http://localhost:7500",
+      "/schedule": "http://localhost:7500",
     },
   },
 });